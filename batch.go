@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultBatchConcurrency = 8
+	maxBatchSize            = 100
+)
+
+// The number of items of a batch that are looked up at the same time. Can be
+// set with the BATCH_CONCURRENCY environment variable.
+var batchConcurrency = defaultBatchConcurrency
+
+// BatchItem is one text fragment to check, such as a post on a page. The id
+// is chosen by the client and returned with its result.
+type BatchItem struct {
+	Id   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// BatchResult is the result of one item of a batch: either the same response
+// as GET /cofacts would give for the text, or the error that prevented it.
+type BatchResult struct {
+	Id     string          `json:"id"`
+	Result *CofactResponse `json:"result,omitempty"`
+	Error  *APIError       `json:"error,omitempty"`
+}
+
+// BatchResponse is the response of POST /cofacts/batch.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// checkBatch checks every item with at most batchConcurrency lookups at the
+// same time. The results are in the same order as the items. A failing item
+// only fails its own result. If each isn't nil, it is called with every
+// result as soon as it is ready, from the goroutine that made it.
+func checkBatch(ctx context.Context, items []BatchItem, config MatchConfig, each func(BatchResult)) []BatchResult {
+	results := make([]BatchResult, len(items))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		results[i].Id = item.Id
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			// Don't start lookups for a client that went away
+			results[i].Error = classifyError(ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(result *BatchResult, text string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := checkText(ctx, text, config)
+			if err != nil {
+				result.Error = classifyError(err)
+			} else {
+				result.Result = resp
+			}
+			if each != nil {
+				each(*result)
+			}
+		}(&results[i], item.Text)
+	}
+	wg.Wait()
+	return results
+}
+
+// handleCofactsBatch checks a JSON array of items in one request, so the
+// extension doesn't need a request per post when it scans a whole page.
+func handleCofactsBatch(c *gin.Context) {
+	config, err := matchConfig.withOverrides(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, invalidInput(err))
+		return
+	}
+
+	var items []BatchItem
+	if err := c.ShouldBindJSON(&items); err != nil {
+		respondError(c, invalidInput(err))
+		return
+	}
+	if len(items) > maxBatchSize {
+		respondError(c, invalidInput(
+			fmt.Errorf("a batch can have at most %d items, got %d", maxBatchSize, len(items))))
+		return
+	}
+
+	results := checkBatch(c.Request.Context(), items, config, nil)
+	for i := range results {
+		if results[i].Error != nil {
+			results[i].Error = withRequestId(c, results[i].Error)
+		}
+	}
+	if _, ok := c.GetQuery("debug"); ok || DEBUG {
+		for _, result := range results {
+			if result.Result != nil {
+				result.Result.Config = &config
+			}
+		}
+	}
+	c.JSON(http.StatusOK, BatchResponse{Results: results})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedBackend blocks every call until release is closed, and keeps track of
+// how many calls are running at the same time.
+type gatedBackend struct {
+	next    CofactsBackend
+	release chan struct{}
+
+	mu            sync.Mutex
+	active, limit int
+}
+
+func (b *gatedBackend) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	b.mu.Lock()
+	b.active++
+	if b.active > b.limit {
+		b.limit = b.active
+	}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.active--
+		b.mu.Unlock()
+	}()
+
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return b.next.ListArticles(ctx, query)
+}
+
+func (b *gatedBackend) running() (active int, limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.active, b.limit
+}
+
+func TestCheckBatch(t *testing.T) {
+	saved := cofacts
+	defer func() { cofacts = saved }()
+	cofacts = &fakeBackend{
+		Articles: testArticles,
+		Errors: map[string]error{
+			"逾時的文字": context.DeadlineExceeded,
+			"壞掉的文字": &UpstreamStatusError{StatusCode: http.StatusServiceUnavailable},
+		},
+	}
+
+	items := []BatchItem{
+		{Id: "rumor", Text: testArticles[0].Text},
+		{Id: "empty", Text: " ！ "},
+		{Id: "timeout", Text: "逾時的文字"},
+		{Id: "weather", Text: testArticles[1].Text},
+		{Id: "unavailable", Text: "壞掉的文字"},
+		{Id: "", Text: testArticles[0].Text},
+	}
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	results := checkBatch(context.Background(), items, defaultMatchConfig(), func(result BatchResult) {
+		mu.Lock()
+		seen[result.Id]++
+		mu.Unlock()
+	})
+
+	tests := []struct {
+		// The id of the matching article and the verdict of the result, or
+		// the code of its error
+		match, verdict, code string
+	}{
+		{match: "rumor", verdict: ReplyRumor},
+		{code: ErrInvalidInput},
+		{code: ErrTimeout},
+		{match: "weather"},
+		{code: ErrUpstreamUnavailable},
+		{match: "rumor", verdict: ReplyRumor},
+	}
+	if len(results) != len(items) {
+		t.Fatalf("got %d results for %d items", len(results), len(items))
+	}
+	for i, test := range tests {
+		result := results[i]
+		if result.Id != items[i].Id {
+			t.Errorf("result %d has id %q, want %q", i, result.Id, items[i].Id)
+		}
+		if test.code != "" {
+			if result.Result != nil || result.Error == nil || result.Error.Code != test.code {
+				t.Errorf("%q: got %+v and error %+v, want error %s", result.Id, result.Result, result.Error, test.code)
+			}
+			continue
+		}
+		if result.Error != nil || result.Result == nil {
+			t.Errorf("%q: got error %+v", result.Id, result.Error)
+			continue
+		}
+		var matches []string
+		for _, edge := range result.Result.Data.ListArticles.Edges {
+			if edge.Node.IsMatch {
+				matches = append(matches, edge.Node.Id)
+			}
+		}
+		if len(matches) != 1 || matches[0] != test.match {
+			t.Errorf("%q: matches %q, want %s", result.Id, matches, test.match)
+		}
+		verdict := ""
+		if result.Result.Verdict != nil {
+			verdict = result.Result.Verdict.Verdict
+		}
+		if verdict != test.verdict {
+			t.Errorf("%q: verdict %q, want %q", result.Id, verdict, test.verdict)
+		}
+	}
+
+	// Every result was passed to each once
+	want := map[string]int{"rumor": 1, "empty": 1, "timeout": 1, "weather": 1, "unavailable": 1, "": 1}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("each was called with %v, want %v", seen, want)
+	}
+}
+
+func TestCheckBatchConcurrency(t *testing.T) {
+	savedConcurrency := batchConcurrency
+	batchConcurrency = 3
+	saved := cofacts
+	defer func() {
+		cofacts = saved
+		batchConcurrency = savedConcurrency
+	}()
+	backend := &gatedBackend{next: &fakeBackend{Articles: testArticles}, release: make(chan struct{})}
+	cofacts = backend
+
+	var items []BatchItem
+	for i := 0; i < 10; i++ {
+		items = append(items, BatchItem{Id: fmt.Sprint(i), Text: fmt.Sprintf("第%d則訊息", i)})
+	}
+	done := make(chan []BatchResult)
+	go func() {
+		done <- checkBatch(context.Background(), items, defaultMatchConfig(), nil)
+	}()
+
+	// Wait for the first lookups to block, and make sure no more start
+	deadline := time.Now().Add(5 * time.Second)
+	for active, _ := backend.running(); active < batchConcurrency; active, _ = backend.running() {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d lookups started", active)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if active, _ := backend.running(); active != batchConcurrency {
+		t.Errorf("%d lookups running, want %d", active, batchConcurrency)
+	}
+
+	close(backend.release)
+	results := <-done
+	for i, result := range results {
+		if result.Id != items[i].Id || result.Error != nil || result.Result == nil {
+			t.Errorf("result %d = %+v", i, result)
+		}
+	}
+	if _, limit := backend.running(); limit != batchConcurrency {
+		t.Errorf("at most %d lookups ran at the same time, want %d", limit, batchConcurrency)
+	}
+}
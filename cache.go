@@ -0,0 +1,203 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCacheSize        = 10000
+	defaultCacheTTL         = 24 * time.Hour
+	defaultCacheNegativeTTL = 10 * time.Minute
+)
+
+// CacheStats are the counters exposed on /stats.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Coalesced int64 `json:"coalesced"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+type cacheEntry struct {
+	key     string
+	resp    *CofactResponse
+	expires time.Time
+}
+
+// inflightCall is an upstream call that other requests for the same key can
+// wait for instead of making their own.
+type inflightCall struct {
+	done chan struct{}
+	resp *CofactResponse
+	err  error
+
+	// The number of requests waiting for the call, and how to cancel it when
+	// the last of them gives up. Guarded by cachedBackend.mu.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// cachedBackend is a CofactsBackend that keeps the most recently used
+// responses of the next backend in memory. The extension sends the same
+// viral messages over and over, so most requests never reach Cofacts.
+//
+// Entries are keyed on the normalized text and page, expire after TTL, or after
+// NegativeTTL if Cofacts found no articles at all, so new articles for a
+// message show up reasonably soon. Concurrent requests for the same text that
+// isn't cached yet share a single upstream call. That call isn't tied to any
+// of the requests, so one client giving up doesn't fail the others; it has its
+// own Timeout instead, and each request stops waiting when its context ends.
+// Once the last waiting request has given up, the call is cancelled, since
+// nobody needs its result anymore.
+type cachedBackend struct {
+	next        CofactsBackend
+	size        int
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	Timeout     time.Duration
+
+	mu       sync.Mutex
+	lru      *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+	inflight map[string]*inflightCall
+
+	hits, misses, coalesced, evictions int64
+}
+
+func newCachedBackend(next CofactsBackend, size int) *cachedBackend {
+	return &cachedBackend{
+		next:        next,
+		size:        size,
+		TTL:         defaultCacheTTL,
+		NegativeTTL: defaultCacheNegativeTTL,
+		Timeout:     defaultCofactsTimeout,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		inflight:    make(map[string]*inflightCall),
+	}
+}
+
+// The cache used by the handlers if enabled, for /stats.
+var responseCache *cachedBackend
+
+// cacheKey is the normalized text for the first page of the default size,
+// so the cache commands can find entries by text, with the page size and
+// cursor appended for other pages.
+func cacheKey(query ArticleQuery) string {
+	key := removeWhitespace(textNormalizer.normalize(query.Text)).String()
+	if (query.First != 0 && query.First != defaultPageSize) || query.After != "" {
+		key += fmt.Sprintf("\x00%d\x00%s", query.First, query.After)
+	}
+	return key
+}
+
+func (cache *cachedBackend) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	key := cacheKey(query)
+
+	cache.mu.Lock()
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			cache.lru.MoveToFront(element)
+			cache.mu.Unlock()
+			atomic.AddInt64(&cache.hits, 1)
+			return entry.resp.clone(), nil
+		}
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+	}
+	atomic.AddInt64(&cache.misses, 1)
+
+	call, ok := cache.inflight[key]
+	if ok {
+		atomic.AddInt64(&cache.coalesced, 1)
+	} else {
+		fetchCtx, cancel := context.WithTimeout(context.Background(), cache.Timeout)
+		call = &inflightCall{done: make(chan struct{}), cancel: cancel}
+		cache.inflight[key] = call
+		go cache.fetch(fetchCtx, key, query, call)
+	}
+	call.waiters++
+	cache.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		cache.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			// Later requests make a new call instead of joining this one
+			if cache.inflight[key] == call {
+				delete(cache.inflight, key)
+			}
+		}
+		cache.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	return call.resp.clone(), nil
+}
+
+// fetch makes the upstream call shared by the requests waiting for key.
+func (cache *cachedBackend) fetch(ctx context.Context, key string, query ArticleQuery, call *inflightCall) {
+	defer call.cancel()
+	call.resp, call.err = cache.next.ListArticles(ctx, query)
+
+	cache.mu.Lock()
+	if cache.inflight[key] == call {
+		delete(cache.inflight, key)
+	}
+	if call.err == nil {
+		cache.add(key, call.resp)
+	}
+	cache.mu.Unlock()
+	close(call.done)
+}
+
+// add stores a response, evicting the least recently used entries if the
+// cache is full. It must be called with cache.mu held.
+func (cache *cachedBackend) add(key string, resp *CofactResponse) {
+	ttl := cache.TTL
+	if len(resp.Data.ListArticles.Edges) == 0 {
+		ttl = cache.NegativeTTL
+	}
+	entry := &cacheEntry{key: key, resp: resp, expires: time.Now().Add(ttl)}
+	cache.entries[key] = cache.lru.PushFront(entry)
+
+	for cache.lru.Len() > cache.size {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).key)
+		atomic.AddInt64(&cache.evictions, 1)
+	}
+}
+
+func (cache *cachedBackend) Stats() CacheStats {
+	cache.mu.Lock()
+	entries := cache.lru.Len()
+	cache.mu.Unlock()
+	return CacheStats{
+		Hits:      atomic.LoadInt64(&cache.hits),
+		Misses:    atomic.LoadInt64(&cache.misses),
+		Coalesced: atomic.LoadInt64(&cache.coalesced),
+		Evictions: atomic.LoadInt64(&cache.evictions),
+		Entries:   entries,
+	}
+}
+
+// clone copies the response so the matching results a handler adds to the
+// nodes don't end up in the cached copy.
+func (resp *CofactResponse) clone() *CofactResponse {
+	c := *resp
+	c.Data.ListArticles.Edges = append([]Edge(nil), resp.Data.ListArticles.Edges...)
+	return &c
+}
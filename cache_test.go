@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowBackend answers after release is closed, or fails when the context of
+// the call ends first, counting the calls that were cancelled that way.
+type slowBackend struct {
+	calls, cancelled int32
+	release          chan struct{}
+}
+
+func (b *slowBackend) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	atomic.AddInt32(&b.calls, 1)
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		atomic.AddInt32(&b.cancelled, 1)
+		return nil, ctx.Err()
+	}
+	resp := &CofactResponse{}
+	resp.Data.ListArticles.Edges = []Edge{{Node: Node{Id: "a", Text: query.Text}}}
+	return resp, nil
+}
+
+func TestCachedBackendCoalescing(t *testing.T) {
+	backend := &slowBackend{release: make(chan struct{})}
+	cache := newCachedBackend(backend, 10)
+
+	// The first request gives up while the others keep waiting
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.ListArticles(first, ArticleQuery{Text: "同一則訊息"})
+		firstErr <- err
+	}()
+	for atomic.LoadInt32(&backend.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cache.ListArticles(context.Background(), ArticleQuery{Text: "同一則訊息"})
+			if err == nil && len(resp.Data.ListArticles.Edges) != 1 {
+				t.Errorf("got %d edges", len(resp.Data.ListArticles.Edges))
+			}
+			errs <- err
+		}()
+	}
+	for cache.Stats().Coalesced < 5 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-firstErr; err != context.Canceled {
+		t.Errorf("the cancelled request returned %v", err)
+	}
+	close(backend.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("a waiting request failed: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != 1 {
+		t.Errorf("%d upstream calls, want 1", n)
+	}
+
+	// The shared call filled the cache
+	if _, err := cache.ListArticles(context.Background(), ArticleQuery{Text: "同一則訊息"}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Entries != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// waitFor polls until cond holds, or fails the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCachedBackendCancel(t *testing.T) {
+	backend := &slowBackend{release: make(chan struct{})}
+	defer close(backend.release)
+	cache := newCachedBackend(backend, 10)
+
+	var cancels []context.CancelFunc
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+		go func() {
+			_, err := cache.ListArticles(ctx, ArticleQuery{Text: "沒人等的訊息"})
+			errs <- err
+		}()
+	}
+	waitFor(t, "the requests to share a call", func() bool { return cache.Stats().Coalesced == 2 })
+
+	// The call keeps running while anyone waits for it
+	for _, cancel := range cancels[:2] {
+		cancel()
+		if err := <-errs; err != context.Canceled {
+			t.Errorf("a cancelled request returned %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&backend.cancelled); n != 0 {
+		t.Fatalf("the call was cancelled while a request still waited for it")
+	}
+
+	// And is cancelled when the last one gives up
+	cancels[2]()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("the last request returned %v", err)
+	}
+	waitFor(t, "the call to be cancelled", func() bool { return atomic.LoadInt32(&backend.cancelled) == 1 })
+
+	// A new request makes a new call instead of getting the cancelled one
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cache.ListArticles(ctx, ArticleQuery{Text: "沒人等的訊息"}); err != context.DeadlineExceeded {
+		t.Errorf("a new request returned %v", err)
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != 2 {
+		t.Errorf("%d upstream calls, want 2", n)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("the cancelled call was cached: %+v", stats)
+	}
+}
+
+func TestCachedBackendTimeout(t *testing.T) {
+	backend := &slowBackend{release: make(chan struct{})}
+	defer close(backend.release)
+	cache := newCachedBackend(backend, 10)
+	cache.Timeout = 20 * time.Millisecond
+
+	_, err := cache.ListArticles(context.Background(), ArticleQuery{Text: "沒有回應"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the cache's own timeout", err)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("the failed call was cached: %+v", stats)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	text := "請 大家 轉傳"
+	first := cacheKey(ArticleQuery{Text: text})
+	if got := cacheKey(ArticleQuery{Text: text, First: defaultPageSize}); got != first {
+		t.Errorf("the default page size changes the key: %q, %q", got, first)
+	}
+	if got := cacheKey(ArticleQuery{Text: "請大家轉傳"}); got != first {
+		t.Errorf("whitespace changes the key: %q, %q", got, first)
+	}
+	keys := map[string]bool{first: true}
+	for _, query := range []ArticleQuery{
+		{Text: text, First: 10},
+		{Text: text, After: "c1"},
+		{Text: text, First: 10, After: "c1"},
+		{Text: text, After: "c2"},
+	} {
+		key := cacheKey(query)
+		if keys[key] {
+			t.Errorf("%+v has the same key as another page", query)
+		}
+		keys[key] = true
+	}
+}
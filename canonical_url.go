@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// canonicalUrl is a url reduced to the parts that identify the page it points
+// to, so that different ways of writing the same link compare equal. Rules
+// lists the canonicalization rules that changed something about the url.
+type canonicalUrl struct {
+	Host  string
+	Path  string
+	Query url.Values
+	Rules []string
+}
+
+func (u *canonicalUrl) String() string {
+	s := u.Host + u.Path
+	if len(u.Query) > 0 {
+		s += "?" + u.Query.Encode()
+	}
+	return s
+}
+
+func (u *canonicalUrl) addRule(rule string) {
+	for _, r := range u.Rules {
+		if r == rule {
+			return
+		}
+	}
+	u.Rules = append(u.Rules, rule)
+}
+
+// Subdomains that serve the same content as the bare domain.
+var equivalentHostPrefixes = []string{"www.", "m.", "mobile."}
+
+// Query parameters added by analytics and share buttons, which never change
+// the page that is shown.
+var trackingParameters = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"yclid":   true,
+	"msclkid": true,
+}
+
+var trackingParameterPrefixes = []string{"utm_"}
+
+func isTrackingParameter(key string) bool {
+	key = strings.ToLower(key)
+	if trackingParameters[key] {
+		return true
+	}
+	for _, prefix := range trackingParameterPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Index pages that are served for the directory they are in.
+var indexPages = []string{"index.html", "index.htm", "index.php"}
+
+// A domainRule rewrites urls for sites that have several url formats for the
+// same content. It returns false if it doesn't apply to the url.
+type domainRule struct {
+	Name  string
+	Apply func(u *canonicalUrl) bool
+}
+
+var domainRules = []domainRule{
+	{"youtube", canonicalizeYoutube},
+	{"facebook", canonicalizeFacebook},
+	{"linetoday", canonicalizeLineToday},
+}
+
+// canonicalizeUrl parses raw and reduces it to its canonical form: the scheme,
+// port, fragment and tracking parameters are dropped, the host is lowercased
+// without www./m./mobile. prefixes, the path is unescaped without trailing
+// slash or index page, and the query parameters are sorted. Finally the
+// domain specific rules are applied.
+func canonicalizeUrl(raw string) (*canonicalUrl, error) {
+	if !strings.Contains(raw, "://") {
+		// xurls also finds links without a scheme, which url.Parse would
+		// otherwise treat as a path.
+		raw = "http://" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &canonicalUrl{
+		Host:  strings.ToLower(parsed.Hostname()),
+		Path:  parsed.Path,
+		Query: url.Values{},
+	}
+
+	for _, prefix := range equivalentHostPrefixes {
+		if strings.HasPrefix(u.Host, prefix) {
+			u.Host = strings.TrimPrefix(u.Host, prefix)
+			u.addRule("host")
+			break
+		}
+	}
+
+	for _, index := range indexPages {
+		if path.Base(u.Path) == index {
+			u.Path = path.Dir(u.Path)
+			u.addRule("index")
+			break
+		}
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+
+	for key, values := range parsed.Query() {
+		if isTrackingParameter(key) {
+			u.addRule("tracking")
+			continue
+		}
+		sort.Strings(values)
+		u.Query[key] = values
+	}
+
+	for _, rule := range domainRules {
+		if rule.Apply(u) {
+			u.addRule(rule.Name)
+			break
+		}
+	}
+	return u, nil
+}
+
+// canonicalizeYoutube maps youtu.be/ID, youtube.com/shorts/ID and the embed
+// and live variants to youtube.com/watch?v=ID, dropping playlist, time and
+// other player parameters.
+func canonicalizeYoutube(u *canonicalUrl) bool {
+	var id string
+	switch u.Host {
+	case "youtu.be":
+		id = strings.Trim(u.Path, "/")
+	case "youtube.com", "youtube-nocookie.com":
+		if u.Path == "/watch" {
+			id = u.Query.Get("v")
+			break
+		}
+		for _, prefix := range []string{"/shorts/", "/embed/", "/live/", "/v/"} {
+			if strings.HasPrefix(u.Path, prefix) {
+				id = strings.TrimPrefix(u.Path, prefix)
+				break
+			}
+		}
+	default:
+		return false
+	}
+	if id == "" || strings.Contains(id, "/") {
+		return false
+	}
+
+	u.Host = "youtube.com"
+	u.Path = "/watch"
+	u.Query = url.Values{"v": {id}}
+	return true
+}
+
+// canonicalizeFacebook maps the various permalink formats of a post to
+// facebook.com/<page or user id>/posts/<post id>.
+func canonicalizeFacebook(u *canonicalUrl) bool {
+	switch u.Host {
+	case "facebook.com", "fb.com", "web.facebook.com":
+	default:
+		return false
+	}
+
+	switch u.Path {
+	case "/permalink.php", "/story.php":
+		// ?story_fbid=<post id>&id=<page or user id>
+		story, id := u.Query.Get("story_fbid"), u.Query.Get("id")
+		if story == "" || id == "" {
+			return false
+		}
+		u.Path = "/" + id + "/posts/" + story
+	default:
+		// /groups/<group>/permalink/<post id> is the same as /groups/<group>/posts/<post id>
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) == 4 && parts[0] == "groups" && parts[2] == "permalink" {
+			parts[2] = "posts"
+			u.Path = "/" + strings.Join(parts, "/")
+		} else if len(parts) != 3 || parts[1] != "posts" {
+			return false
+		}
+	}
+	u.Host = "facebook.com"
+	u.Query = url.Values{}
+	return true
+}
+
+// canonicalizeLineToday maps the different generations of LINE Today article
+// urls, such as /tw/v2/article/ID, /TW/article/ID and /tw/pc/article/ID, to
+// today.line.me/article/ID.
+func canonicalizeLineToday(u *canonicalUrl) bool {
+	if u.Host != "today.line.me" {
+		return false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, part := range parts {
+		if part == "article" && i == len(parts)-2 {
+			u.Path = "/article/" + parts[i+1]
+			u.Query = url.Values{}
+			return true
+		}
+	}
+	return false
+}
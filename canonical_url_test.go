@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"mvdan.cc/xurls/v2"
+)
+
+// Messages like the ones sent to the extension, and hyperlinks like the ones
+// Cofacts extracted from its articles.
+var urlCorpus = []string{
+	"轉傳！衛福部公告 https://www.mohw.gov.tw/cp-16-48610-1.html 請大家注意",
+	"看這個影片 https://youtu.be/dQw4w9WgXcQ?t=42 太誇張了",
+	"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123&feature=share",
+	"https://m.facebook.com/story.php?story_fbid=1234567890&id=100004",
+	"https://www.facebook.com/groups/taiwan/permalink/987654321/",
+	"今日新聞 https://today.line.me/tw/v2/article/abc123XYZ?utm_source=line",
+	"速看 http://example.com/news/index.html?id=5&fbclid=IwAR0abc",
+	"link without scheme www.cdc.gov.tw/Bulletin/Detail/abc?typeid=9",
+	"https://example.com:8443/path/to/page/#section",
+	"兩個連結 https://a.example.org/x 和 https://b.example.org/y?z=1",
+	"https://zh.wikipedia.org/wiki/%E5%8F%B0%E7%81%A3",
+	"https://example.com/搜尋?q=疫苗",
+}
+
+// Rewrites of a url that point to the same page.
+var equivalentVariants = []func(u string) string{
+	func(u string) string { return u },
+	func(u string) string { return addParam(u, "utm_source=line&utm_medium=share") },
+	func(u string) string { return addParam(u, "fbclid=IwAR123") },
+	func(u string) string { return replacePrefix(u, "https://", "http://") },
+	func(u string) string { return replacePrefix(u, "http://", "https://") },
+	func(u string) string {
+		return replacePrefix(replacePrefix(u, "https://www.", "https://"), "http://www.", "http://")
+	},
+	func(u string) string { return withFragment(u, "top") },
+}
+
+func replacePrefix(u string, prefix string, replacement string) string {
+	if strings.HasPrefix(u, prefix) {
+		return replacement + u[len(prefix):]
+	}
+	return u
+}
+
+func addParam(u string, param string) string {
+	if i := strings.Index(u, "#"); i >= 0 {
+		u = u[:i]
+	}
+	if strings.Contains(u, "?") {
+		return u + "&" + param
+	}
+	return u + "?" + param
+}
+
+func withFragment(u string, fragment string) string {
+	if i := strings.Index(u, "#"); i >= 0 {
+		u = u[:i]
+	}
+	return u + "#" + fragment
+}
+
+func corpusUrls(t *testing.T) []string {
+	var urls []string
+	rx := xurls.Strict()
+	for _, message := range urlCorpus {
+		urls = append(urls, rx.FindAllString(message, -1)...)
+	}
+	if len(urls) < len(urlCorpus) {
+		t.Fatalf("xurls found only %d urls in the corpus", len(urls))
+	}
+	return urls
+}
+
+func TestIsEquivalentCorpus(t *testing.T) {
+	for _, u := range corpusUrls(t) {
+		for i, variant := range equivalentVariants {
+			v := variant(u)
+			equivalent, _, err := isEquivalent(u, v)
+			if err != nil {
+				t.Errorf("isEquivalent(%q, %q): %v", u, v, err)
+			} else if !equivalent {
+				t.Errorf("variant %d: %q is not equivalent to %q", i, v, u)
+			}
+		}
+	}
+}
+
+func TestIsEquivalentRules(t *testing.T) {
+	tests := []struct {
+		article, query string
+		equivalent     bool
+		rules          []string
+	}{
+		{"https://youtu.be/dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1", true, []string{"youtube", "host"}},
+		{"https://www.youtube.com/shorts/abc", "https://youtube.com/watch?v=abc", true, []string{"host", "youtube"}},
+		{"https://facebook.com/permalink.php?story_fbid=1&id=2", "https://www.facebook.com/2/posts/1", true, []string{"facebook", "host"}},
+		{"https://today.line.me/TW/article/xyz", "https://today.line.me/tw/v2/article/xyz", true, []string{"linetoday"}},
+		{"http://example.com/a/index.html", "http://example.com/a/", true, []string{"index"}},
+		// The query may have extra parameters, but not miss the article's
+		{"http://example.com/a?id=1", "http://example.com/a?id=1&page=2", true, nil},
+		{"http://example.com/a?id=1&page=2", "http://example.com/a?id=1", false, nil},
+		{"http://example.com/a", "http://example.org/a", false, nil},
+		{"http://example.com/a", "http://example.com/b", false, nil},
+	}
+	for _, test := range tests {
+		equivalent, rules, err := isEquivalent(test.article, test.query)
+		if err != nil {
+			t.Errorf("isEquivalent(%q, %q): %v", test.article, test.query, err)
+			continue
+		}
+		if equivalent != test.equivalent {
+			t.Errorf("isEquivalent(%q, %q) = %v, want %v", test.article, test.query, equivalent, test.equivalent)
+		}
+		if equivalent && !sameSet(rules, test.rules) {
+			t.Errorf("isEquivalent(%q, %q) used rules %v, want %v", test.article, test.query, rules, test.rules)
+		}
+	}
+}
+
+func sameSet(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool)
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestIsEquivalentRandom feeds random and mangled urls through xurls and the
+// comparator. It must never panic, a url must always match itself, and
+// equivalent variants must match.
+func TestIsEquivalentRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	pieces := []string{"http://", "https://", "www.", "m.", "example.com", "youtu.be", "facebook.com",
+		"today.line.me", "/", "/a", "/posts/", "/article/", "/index.html", "?", "&", "=", "#",
+		"%", "%zz", "%E5%8F%B0", "[", "]", ":", "::1", "@", "v", "id", "utm_x", "台灣", " ", "..", ":99999"}
+	rx := xurls.Strict()
+	for i := 0; i < 5000; i++ {
+		var b strings.Builder
+		for n := rng.Intn(12); n >= 0; n-- {
+			b.WriteString(pieces[rng.Intn(len(pieces))])
+		}
+		raw := b.String()
+
+		// Arbitrary strings, as Cofacts hyperlinks can be anything
+		isEquivalent(raw, raw)
+
+		for _, u := range rx.FindAllString(raw, -1) {
+			equivalent, _, err := isEquivalent(u, u)
+			if err == nil && !equivalent {
+				t.Fatalf("%q is not equivalent to itself", u)
+			}
+			if err != nil {
+				continue
+			}
+			variant := equivalentVariants[rng.Intn(len(equivalentVariants))](u)
+			if equivalent, _, err := isEquivalent(u, variant); err == nil && !equivalent {
+				t.Fatalf("%q is not equivalent to %q", variant, u)
+			}
+		}
+	}
+}
+
+func TestExistSameUrlWarnings(t *testing.T) {
+	node := &Node{
+		Hyperlinks: []Hyperlink{
+			{Url: "http://[::1"},
+			{Url: "http://[::1"},
+			{Url: "https://www.mohw.gov.tw/cp-16-48610-1.html"},
+		},
+	}
+	match := exist_same_url(node, []string{"https://mohw.gov.tw/cp-16-48610-1.html?utm_source=line"})
+	if match == nil {
+		t.Fatal("the valid hyperlink didn't match")
+	}
+	if match.NodeUrl != "https://www.mohw.gov.tw/cp-16-48610-1.html" {
+		t.Errorf("matched %q", match.NodeUrl)
+	}
+	if !sameSet(match.Rules, []string{"host", "tracking"}) {
+		t.Errorf("match used rules %v", match.Rules)
+	}
+	// The same broken hyperlink is only reported once
+	if len(node.Warnings) != 1 || !strings.Contains(node.Warnings[0], "[::1") {
+		t.Errorf("warnings = %q, want one for the broken hyperlink", node.Warnings)
+	}
+
+	node = &Node{Hyperlinks: []Hyperlink{{Url: "https://example.com/a"}}}
+	if match := exist_same_url(node, []string{"http://[bad"}); match != nil || len(node.Warnings) != 1 {
+		t.Errorf("broken query url: match %v, warnings %q", match, node.Warnings)
+	}
+}
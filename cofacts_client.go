@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCofactsEndpoint = "https://cofacts-api.g0v.tw/graphql"
+	defaultCofactsTimeout  = 10 * time.Second
+	defaultUserAgent       = "chrome-extension-server"
+
+	// A page of articles is well below a megabyte, so anything bigger than
+	// this is not a response we can use.
+	maxCofactsResponseSize = 8 << 20
+	// How much of the body of an error response is kept for the logs.
+	maxErrorBodySize = 1 << 10
+)
+
+// errCofactsResponseTooLarge is returned when Cofacts answers with more than
+// maxCofactsResponseSize bytes.
+var errCofactsResponseTooLarge = fmt.Errorf("cofacts response is larger than %d bytes", maxCofactsResponseSize)
+
+// ArticleQuery asks for a page of the Cofacts articles most similar to Text.
+type ArticleQuery struct {
+	Text string
+
+	// The number of articles in the page, or the backend's default if 0.
+	First int
+
+	// The cursor of the last article of the previous page, or empty for the
+	// first page.
+	After string
+}
+
+// CofactsBackend finds the Cofacts articles most similar to a text. The
+// handlers only depend on this interface, so they can be tested against a
+// fake backend without network access.
+type CofactsBackend interface {
+	ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error)
+}
+
+// The backend used by the handlers, set up in main.
+var cofacts CofactsBackend
+
+// GraphQLError is one entry of the errors array in a GraphQL response.
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// GraphQLErrors is returned when Cofacts answers with a non-empty errors
+// array, meaning the query itself failed.
+type GraphQLErrors []GraphQLError
+
+func (errs GraphQLErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+	}
+	return "cofacts graphql error: " + strings.Join(messages, "; ")
+}
+
+// UpstreamStatusError is returned when Cofacts answers with a status other
+// than 200 OK. Body has at most maxErrorBodySize bytes of the response, for
+// the logs; it isn't passed on to clients.
+type UpstreamStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("cofacts returned %d %s: %s",
+		e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// CofactsClient is the CofactsBackend that calls the Cofacts GraphQL api.
+type CofactsClient struct {
+	Endpoint   string
+	AppId      string
+	UserAgent  string
+	HTTPClient *http.Client
+}
+
+func newCofactsClient(endpoint string, appId string, timeout time.Duration) *CofactsClient {
+	return &CofactsClient{
+		Endpoint:   endpoint,
+		AppId:      appId,
+		UserAgent:  defaultUserAgent,
+		HTTPClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (client *CofactsClient) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	type CofactsRequestVariables struct {
+		Text  string `json:"text"`
+		First int    `json:"first"`
+		After string `json:"after,omitempty"`
+	}
+
+	type CofactsRequest struct {
+		Query     string                  `json:"query"`
+		Variables CofactsRequestVariables `json:"variables"`
+	}
+
+	if query.First == 0 {
+		query.First = defaultPageSize
+	}
+	cofactsQuery := CofactsRequest{
+		Query:     cofactsGqlQuery,
+		Variables: CofactsRequestVariables{Text: query.Text, First: query.First, After: query.After},
+	}
+
+	body, err := json.Marshal(&cofactsQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, client.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", client.UserAgent)
+	if client.AppId != "" {
+		req.Header.Set("x-app-id", client.AppId)
+	}
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return nil, &UpstreamStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	respText, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCofactsResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(respText) > maxCofactsResponseSize {
+		return nil, errCofactsResponseTooLarge
+	}
+
+	var respData struct {
+		CofactResponse
+		Errors GraphQLErrors `json:"errors"`
+	}
+	err = json.Unmarshal(respText, &respData)
+	if err != nil {
+		return nil, err
+	}
+	if len(respData.Errors) > 0 {
+		return nil, respData.Errors
+	}
+
+	return &respData.CofactResponse, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCofactsClientListArticles(t *testing.T) {
+	recorded, err := ioutil.ReadFile(filepath.Join("testdata", "cofacts_list_articles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		if req.Query != cofactsGqlQuery {
+			t.Errorf("sent query %q", req.Query)
+		}
+		if req.Variables["text"] != "喝溫開水" || req.Variables["first"] != float64(defaultPageSize) {
+			t.Errorf("sent variables %v", req.Variables)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(recorded)
+	}))
+	defer server.Close()
+
+	client := newCofactsClient(server.URL, "", defaultCofactsTimeout)
+	resp, err := client.ListArticles(context.Background(), ArticleQuery{Text: "喝溫開水"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := resp.Data.ListArticles.Edges
+	if len(edges) != 2 {
+		t.Fatalf("decoded %d edges, want 2", len(edges))
+	}
+
+	node := edges[0].Node
+	if node.CreatedAt != "2020-01-28T03:12:45.118Z" || node.ReplyCount != 4 || node.ReplyRequestCount != 37 {
+		t.Errorf("node = %+v", node)
+	}
+	first := node.ArticleReplies[0]
+	if first.Status != "NORMAL" || first.CreatedAt != "2020-01-28T05:00:01.532Z" ||
+		first.PositiveFeedbackCount != 2 || first.NegativeFeedbackCount != 3 ||
+		first.Reply.CreatedAt != "2020-01-28T05:00:01.532Z" {
+		t.Errorf("first article reply = %+v", first)
+	}
+
+	// The deleted reply goes, the rest are ordered by their balance of
+	// feedback. The two rumor replies both have 14, so the one with more
+	// positive feedback goes first.
+	orderReplies(&node)
+	var ids []string
+	for _, ar := range node.ArticleReplies {
+		ids = append(ids, ar.Reply.Id)
+	}
+	if want := []string{"r-rumor", "r-rumor-2", "r-opinion"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ordered replies = %v, want %v", ids, want)
+	}
+
+	node = edges[1].Node
+	orderReplies(&node)
+	if node.ArticleReplies == nil || len(node.ArticleReplies) != 0 || node.Hyperlinks[0].Url != "https://example.com/weather" {
+		t.Errorf("second node = %+v", node)
+	}
+}
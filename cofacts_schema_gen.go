@@ -0,0 +1,120 @@
+// Code generated by tools/gqlgen from cofacts_schema.json. DO NOT EDIT.
+
+package main
+
+const gqlQueryType = "Query"
+
+type GqlArticleReferenceTypeEnum string
+
+const (
+	GqlArticleReferenceTypeUrl  GqlArticleReferenceTypeEnum = "URL"
+	GqlArticleReferenceTypeLine GqlArticleReferenceTypeEnum = "LINE"
+)
+
+type GqlArticleReplyStatusEnum string
+
+const (
+	GqlArticleReplyStatusNormal  GqlArticleReplyStatusEnum = "NORMAL"
+	GqlArticleReplyStatusDeleted GqlArticleReplyStatusEnum = "DELETED"
+)
+
+type GqlReplyTypeEnum string
+
+const (
+	// Represents untrue or partially untrue message
+	GqlReplyTypeRumor GqlReplyTypeEnum = "RUMOR"
+	// Represents true message
+	GqlReplyTypeNotRumor GqlReplyTypeEnum = "NOT_RUMOR"
+	// Represents a message that contains personal opinions
+	GqlReplyTypeOpinionated GqlReplyTypeEnum = "OPINIONATED"
+	// Represents a message that is not within the scope of Cofacts
+	GqlReplyTypeNotArticle GqlReplyTypeEnum = "NOT_ARTICLE"
+)
+
+type GqlSortOrderEnum string
+
+const (
+	GqlSortOrderAsc  GqlSortOrderEnum = "ASC"
+	GqlSortOrderDesc GqlSortOrderEnum = "DESC"
+)
+
+// gqlSchema has the fields of every object type, to check queries
+// against, see validateGqlQuery.
+var gqlSchema = map[string]map[string]gqlFieldDef{
+	"Article": {
+		"id":                {Type: "ID", List: false},
+		"text":              {Type: "String", List: false},
+		"createdAt":         {Type: "String", List: false},
+		"updatedAt":         {Type: "String", List: false},
+		"lastRequestedAt":   {Type: "String", List: false},
+		"references":        {Type: "ArticleReference", List: true},
+		"replyCount":        {Type: "Int", List: false},
+		"articleReplies":    {Type: "ArticleReply", List: true, Args: []string{"status", "statuses"}},
+		"replyRequestCount": {Type: "Int", List: false},
+		"hyperlinks":        {Type: "Hyperlink", List: true},
+		"user":              {Type: "User", List: false},
+	},
+	"ArticleConnection": {
+		"edges":      {Type: "ArticleConnectionEdge", List: true},
+		"totalCount": {Type: "Int", List: false},
+		"pageInfo":   {Type: "ArticleConnectionPageInfo", List: false},
+	},
+	"ArticleConnectionEdge": {
+		"node":      {Type: "Article", List: false},
+		"cursor":    {Type: "String", List: false},
+		"score":     {Type: "Float", List: false},
+		"highlight": {Type: "Highlights", List: false},
+	},
+	"ArticleConnectionPageInfo": {
+		"firstCursor": {Type: "String", List: false},
+		"lastCursor":  {Type: "String", List: false},
+	},
+	"ArticleReference": {
+		"createdAt": {Type: "String", List: false},
+		"type":      {Type: "ArticleReferenceTypeEnum", List: false},
+		"permalink": {Type: "String", List: false},
+	},
+	"ArticleReply": {
+		"replyId":               {Type: "String", List: false},
+		"reply":                 {Type: "Reply", List: false},
+		"articleId":             {Type: "String", List: false},
+		"article":               {Type: "Article", List: false},
+		"user":                  {Type: "User", List: false},
+		"canUpdateStatus":       {Type: "Boolean", List: false},
+		"feedbackCount":         {Type: "Int", List: false},
+		"positiveFeedbackCount": {Type: "Int", List: false},
+		"negativeFeedbackCount": {Type: "Int", List: false},
+		"status":                {Type: "ArticleReplyStatusEnum", List: false},
+		"createdAt":             {Type: "String", List: false},
+		"updatedAt":             {Type: "String", List: false},
+	},
+	"Highlights": {
+		"text":       {Type: "String", List: false},
+		"reference":  {Type: "String", List: false},
+		"hyperlinks": {Type: "Hyperlink", List: true},
+	},
+	"Hyperlink": {
+		"url":     {Type: "String", List: false},
+		"title":   {Type: "String", List: false},
+		"summary": {Type: "String", List: false},
+	},
+	"Query": {
+		"GetArticle":   {Type: "Article", List: false, Args: []string{"id"}},
+		"GetReply":     {Type: "Reply", List: false, Args: []string{"id"}},
+		"ListArticles": {Type: "ArticleConnection", List: false, Args: []string{"filter", "orderBy", "first", "after", "before"}},
+	},
+	"Reply": {
+		"id":         {Type: "ID", List: false},
+		"user":       {Type: "User", List: false},
+		"createdAt":  {Type: "String", List: false},
+		"text":       {Type: "String", List: false},
+		"type":       {Type: "ReplyTypeEnum", List: false},
+		"reference":  {Type: "String", List: false},
+		"hyperlinks": {Type: "Hyperlink", List: true},
+	},
+	"User": {
+		"id":        {Type: "String", List: false},
+		"name":      {Type: "String", List: false},
+		"avatarUrl": {Type: "String", List: false},
+	},
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	StrategyUrl           = "url"
+	StrategyTfidf         = "tfidf"
+	StrategyNearDuplicate = "neardup"
+	StrategyLcs           = "lcs"
+)
+
+var allStrategies = []string{StrategyUrl, StrategyTfidf, StrategyNearDuplicate, StrategyLcs}
+
+// MatchConfig decides how articles are matched against the query. It is read
+// from the config file, can be overridden with environment variables, and
+// per request with query parameters of the same name.
+type MatchConfig struct {
+	// The strategies to use. The url strategy always comes first, wherever
+	// it is listed: if the query contains urls, an article only matches if it
+	// links to one of them, and the text strategies aren't used at all.
+	// Otherwise the text strategies are tried in the order listed, and the
+	// first one that matches decides.
+	Strategies []string `yaml:"strategies" json:"strategies"`
+
+	// Minimum TF-IDF cosine similarity, from 0 to 1, see tfidfScores
+	TfidfThreshold float64 `yaml:"tfidf_threshold" json:"tfidf_threshold"`
+
+	// Minimum estimated Jaccard similarity, from 0 to 1, see
+	// nearDuplicateScore. A chain message of a few sentences with a name
+	// swapped or a sentence added scores 0.75 or more, while two short
+	// messages that only share a boilerplate sentence can reach 0.6.
+	NearDuplicateThreshold float64 `yaml:"neardup_threshold" json:"neardup_threshold"`
+
+	// The longest common substring matches if it is at least LcsMinChars
+	// characters long, or covers at least LcsMinRatio of the query. The
+	// ratio only counts for queries with at least LcsMinQueryChars characters
+	// besides urls, as a short query is easily covered by accident. See
+	// lcsScore.
+	LcsMinChars      int     `yaml:"lcs_min_chars" json:"lcs_min_chars"`
+	LcsMinRatio      float64 `yaml:"lcs_min_ratio" json:"lcs_min_ratio"`
+	LcsMinQueryChars int     `yaml:"lcs_min_query_chars" json:"lcs_min_query_chars"`
+
+	// The number of articles to ask Cofacts for at once, and in total while
+	// none of them match. See listCandidates.
+	PageSize      int `yaml:"page_size" json:"page_size"`
+	MaxCandidates int `yaml:"max_candidates" json:"max_candidates"`
+}
+
+func defaultMatchConfig() MatchConfig {
+	return MatchConfig{
+		Strategies:             []string{StrategyUrl, StrategyTfidf, StrategyNearDuplicate},
+		TfidfThreshold:         0.4,
+		NearDuplicateThreshold: 0.7,
+		LcsMinChars:            25,
+		LcsMinRatio:            0.8,
+		LcsMinQueryChars:       10,
+		PageSize:               defaultPageSize,
+		MaxCandidates:          defaultPageSize,
+	}
+}
+
+// The configuration used unless a request overrides it, set up in main.
+var matchConfig = defaultMatchConfig()
+
+const defaultConfigFile = "config.yml"
+
+const (
+	defaultPageSize = 4
+
+	// Upper bounds, so a request can't make the server fetch and score an
+	// unreasonable number of articles.
+	maxPageSize      = 20
+	maxMaxCandidates = 100
+)
+
+// loadMatchConfig reads the config file, if there is one, on top of the
+// defaults and then applies the MATCH_* environment variables.
+func loadMatchConfig(path string) (MatchConfig, error) {
+	config := defaultMatchConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		var file struct {
+			Match MatchConfig `yaml:"match"`
+		}
+		file.Match = config
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			return config, fmt.Errorf("%s: %v", path, err)
+		}
+		config = file.Match
+	} else if !os.IsNotExist(err) || path != defaultConfigFile {
+		return config, err
+	}
+
+	env := url.Values{}
+	for _, key := range matchConfigKeys {
+		if value, ok := os.LookupEnv("MATCH_" + strings.ToUpper(key)); ok {
+			env.Set(key, value)
+		}
+	}
+	return config.withOverrides(env)
+}
+
+var matchConfigKeys = []string{
+	"strategies",
+	"tfidf_threshold",
+	"neardup_threshold",
+	"lcs_min_chars",
+	"lcs_min_ratio",
+	"lcs_min_query_chars",
+	"page_size",
+	"max_candidates",
+}
+
+// withOverrides returns a copy of the config with the settings in values
+// replaced. Keys that aren't settings are ignored, as the values usually
+// come from the query string.
+func (config MatchConfig) withOverrides(values url.Values) (MatchConfig, error) {
+	parseFloat := func(key string, value string, target *float64) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number: %q", key, value)
+		}
+		*target = f
+		return nil
+	}
+	parseInt := func(key string, value string, target *int) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number: %q", key, value)
+		}
+		*target = i
+		return nil
+	}
+
+	for _, key := range matchConfigKeys {
+		if _, ok := values[key]; !ok {
+			continue
+		}
+		value := values.Get(key)
+		var err error
+		switch key {
+		case "strategies":
+			config.Strategies = nil
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					config.Strategies = append(config.Strategies, s)
+				}
+			}
+		case "tfidf_threshold":
+			err = parseFloat(key, value, &config.TfidfThreshold)
+		case "neardup_threshold":
+			err = parseFloat(key, value, &config.NearDuplicateThreshold)
+		case "lcs_min_chars":
+			err = parseInt(key, value, &config.LcsMinChars)
+		case "lcs_min_ratio":
+			err = parseFloat(key, value, &config.LcsMinRatio)
+		case "lcs_min_query_chars":
+			err = parseInt(key, value, &config.LcsMinQueryChars)
+		case "page_size":
+			err = parseInt(key, value, &config.PageSize)
+		case "max_candidates":
+			err = parseInt(key, value, &config.MaxCandidates)
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, config.validate()
+}
+
+// validate checks the settings. The comparisons are written so NaN, which
+// ParseFloat accepts, fails them.
+func (config MatchConfig) validate() error {
+	if len(config.Strategies) == 0 {
+		return fmt.Errorf("strategies must list at least one of %s", strings.Join(allStrategies, ", "))
+	}
+	if !(config.TfidfThreshold >= 0 && config.TfidfThreshold <= 1) {
+		return fmt.Errorf("tfidf_threshold must be between 0 and 1: %v", config.TfidfThreshold)
+	}
+	if !(config.NearDuplicateThreshold >= 0 && config.NearDuplicateThreshold <= 1) {
+		return fmt.Errorf("neardup_threshold must be between 0 and 1: %v", config.NearDuplicateThreshold)
+	}
+	if config.LcsMinChars < 1 {
+		return fmt.Errorf("lcs_min_chars must be at least 1: %d", config.LcsMinChars)
+	}
+	if !(config.LcsMinRatio > 0 && config.LcsMinRatio <= 1) {
+		return fmt.Errorf("lcs_min_ratio must be more than 0 and at most 1: %v", config.LcsMinRatio)
+	}
+	if config.PageSize < 1 || config.PageSize > maxPageSize {
+		return fmt.Errorf("page_size must be between 1 and %d: %d", maxPageSize, config.PageSize)
+	}
+	if config.MaxCandidates < 1 || config.MaxCandidates > maxMaxCandidates {
+		return fmt.Errorf("max_candidates must be between 1 and %d: %d", maxMaxCandidates, config.MaxCandidates)
+	}
+	for _, s := range config.Strategies {
+		known := false
+		for _, k := range allStrategies {
+			known = known || s == k
+		}
+		if !known {
+			return fmt.Errorf("unknown strategy %q, must be one of %s",
+				s, strings.Join(allStrategies, ", "))
+		}
+	}
+	return nil
+}
+
+func (config MatchConfig) uses(strategy string) bool {
+	for _, s := range config.Strategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
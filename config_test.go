@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchConfigOverrides(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string // part of the error, if any
+	}{
+		{"", ""},
+		{"strategies=lcs,tfidf&tfidf_threshold=0&neardup_threshold=1", ""},
+		{"strategies=", "strategies must list"},
+		{"strategies=,%20,", "strategies must list"},
+		{"strategies=url,magic", "unknown strategy"},
+		{"tfidf_threshold=1.5", "tfidf_threshold must be between"},
+		{"tfidf_threshold=-0.1", "tfidf_threshold must be between"},
+		{"tfidf_threshold=NaN", "tfidf_threshold must be between"},
+		{"tfidf_threshold=high", "tfidf_threshold must be a number"},
+		{"neardup_threshold=2", "neardup_threshold must be between"},
+		{"neardup_threshold=NaN", "neardup_threshold must be between"},
+		{"lcs_min_ratio=0", "lcs_min_ratio must be"},
+		{"lcs_min_chars=0", "lcs_min_chars must be"},
+		{"page_size=21", "page_size must be"},
+		{"max_candidates=0", "max_candidates must be"},
+	}
+	for _, test := range tests {
+		values, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = defaultMatchConfig().withOverrides(values)
+		if test.err == "" && err != nil {
+			t.Errorf("%q: %v", test.query, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%q: got error %v, want %q", test.query, err, test.err)
+		}
+	}
+
+	values, _ := url.ParseQuery("strategies=lcs,%20tfidf&lcs_min_chars=30&other=1")
+	config, err := defaultMatchConfig().withOverrides(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Strategies, []string{StrategyLcs, StrategyTfidf}) || config.LcsMinChars != 30 {
+		t.Errorf("overridden config = %+v", config)
+	}
+}
+
+func TestMatchArticlesStrategyOrder(t *testing.T) {
+	text := "這篇文章說喝熱水可以殺死病毒，請大家快轉傳 https://example.com/news/1"
+	newResponse := func() *CofactResponse {
+		resp := &CofactResponse{}
+		resp.Data.ListArticles.Edges = []Edge{
+			// Links to the query's url, with different text
+			{Node: Node{Id: "a", Text: "完全不同的內容 https://www.example.com/news/1?utm_source=line",
+				Hyperlinks: []Hyperlink{{Url: "https://www.example.com/news/1?utm_source=line"}}}},
+			// The same text, without the url
+			{Node: Node{Id: "b", Text: "這篇文章說喝熱水可以殺死病毒，請大家快轉傳"}},
+		}
+		return resp
+	}
+	nodes := func(resp *CofactResponse) []Node {
+		var n []Node
+		for _, e := range resp.Data.ListArticles.Edges {
+			n = append(n, e.Node)
+		}
+		return n
+	}
+
+	// The url strategy decides whenever the query has urls, even if it is
+	// listed last
+	for _, strategies := range [][]string{{StrategyUrl, StrategyLcs}, {StrategyLcs, StrategyUrl}} {
+		config := defaultMatchConfig()
+		config.Strategies = strategies
+		config.LcsMinChars = 15
+		resp := newResponse()
+		matchArticles(context.Background(), text, resp, config)
+		n := nodes(resp)
+		if !n[0].IsMatch || n[0].Strategy != StrategyUrl || n[1].IsMatch || n[1].Strategy != StrategyUrl {
+			t.Errorf("strategies %v: matches %v %q, %v %q", strategies,
+				n[0].IsMatch, n[0].Strategy, n[1].IsMatch, n[1].Strategy)
+		}
+	}
+
+	// Without the url strategy the text decides
+	config := defaultMatchConfig()
+	config.Strategies = []string{StrategyLcs}
+	config.LcsMinChars = 15
+	resp := newResponse()
+	matchArticles(context.Background(), text, resp, config)
+	if n := nodes(resp)[1]; !n.IsMatch || n.Strategy != StrategyLcs {
+		t.Errorf("lcs only: %v %q", n.IsMatch, n.Strategy)
+	}
+
+	// Of the text strategies, the first that matches decides, in the order
+	// they are listed
+	config.Strategies = []string{StrategyNearDuplicate, StrategyLcs}
+	config.NearDuplicateThreshold = 1
+	resp = newResponse()
+	matchArticles(context.Background(), "這篇文章說喝熱水可以殺死病毒，請大家快轉傳", resp, config)
+	if n := nodes(resp)[1]; !n.IsMatch || n.Strategy != StrategyNearDuplicate {
+		t.Errorf("neardup, lcs: %v %q, signals %v", n.IsMatch, n.Strategy, n.Signals)
+	}
+	config.NearDuplicateThreshold = 0.99
+	config.Strategies = []string{StrategyLcs, StrategyNearDuplicate}
+	resp = newResponse()
+	matchArticles(context.Background(), "這篇文章說喝熱水可以殺死病毒，請大家快轉傳", resp, config)
+	if n := nodes(resp)[1]; !n.IsMatch || n.Strategy != StrategyLcs {
+		t.Errorf("lcs, neardup: %v %q, signals %v", n.IsMatch, n.Strategy, n.Signals)
+	}
+}
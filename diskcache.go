@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Version of the records in the disk cache. Bump it whenever CofactResponse
+// or the query changes, so entries written by an older version are ignored
+// instead of being served with missing fields.
+const cacheSchemaVersion = 4
+
+const (
+	cacheFileName          = "cofacts-cache.log"
+	cacheLockFileName      = "cofacts-cache.lock"
+	defaultCacheDirMaxSize = 100 << 20
+)
+
+// errCacheLocked is returned by openDiskCache if another process, such as a
+// running server, has the cache open.
+var errCacheLocked = errors.New("the disk cache is in use by another process, such as a running server")
+
+// diskCacheRecord is one line in the cache file. A record with Deleted set
+// is a tombstone that removes earlier records for the same key.
+type diskCacheRecord struct {
+	Version int             `json:"v"`
+	Key     string          `json:"key"`
+	Time    time.Time       `json:"time"`
+	Deleted bool            `json:"deleted,omitempty"`
+	Resp    *CofactResponse `json:"resp,omitempty"`
+}
+
+type diskCacheIndexEntry struct {
+	offset   int64
+	length   int
+	time     time.Time
+	articles int
+}
+
+// diskCache is a CofactsBackend that stores the responses of the next backend
+// in an append-only log file, so they survive dyno restarts. Only the offsets
+// of the records are kept in memory. When the file grows beyond MaxSize, it
+// is rewritten with just the newest entries, using about half of MaxSize.
+//
+// Only one process can have the cache open at a time, as each keeps its own
+// index of the file. The others get errCacheLocked.
+type diskCache struct {
+	next        CofactsBackend
+	path        string
+	MaxSize     int64
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	mu    sync.Mutex
+	lock  *os.File
+	file  *os.File
+	size  int64
+	index map[string]diskCacheIndexEntry
+}
+
+func openDiskCache(dir string, next CofactsBackend) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	cache := &diskCache{
+		next:        next,
+		path:        filepath.Join(dir, cacheFileName),
+		MaxSize:     defaultCacheDirMaxSize,
+		TTL:         defaultCacheTTL,
+		NegativeTTL: defaultCacheNegativeTTL,
+	}
+	lock, err := lockCacheDir(filepath.Join(dir, cacheLockFileName))
+	if err != nil {
+		return nil, err
+	}
+	cache.lock = lock
+	if err := cache.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return cache, nil
+}
+
+// load opens the cache file and rebuilds the index from it. A partial last
+// record, left behind if we crashed while writing it, is cut off.
+func (cache *diskCache) load() error {
+	f, err := os.OpenFile(cache.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]diskCacheIndexEntry)
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		var record diskCacheRecord
+		if json.Unmarshal(line, &record) != nil {
+			break
+		}
+		if record.Deleted {
+			delete(index, record.Key)
+		} else if record.Version == cacheSchemaVersion && record.Resp != nil {
+			index[record.Key] = diskCacheIndexEntry{
+				offset:   offset,
+				length:   len(line),
+				time:     record.Time,
+				articles: len(record.Resp.Data.ListArticles.Edges),
+			}
+		}
+		offset += int64(len(line))
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return err
+	}
+
+	cache.file = f
+	cache.size = offset
+	cache.index = index
+	return nil
+}
+
+func (cache *diskCache) Close() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	err := cache.file.Close()
+	if lockErr := cache.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+func (cache *diskCache) isExpired(entry diskCacheIndexEntry) bool {
+	ttl := cache.TTL
+	if entry.articles == 0 {
+		ttl = cache.NegativeTTL
+	}
+	return time.Since(entry.time) > ttl
+}
+
+func (cache *diskCache) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	key := cacheKey(query)
+	if resp, err := cache.get(key); err == nil && resp != nil {
+		return resp, nil
+	}
+
+	resp, err := cache.next.ListArticles(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	// Failing to write the cache shouldn't fail the request.
+	if err := cache.put(key, resp); err != nil {
+		log.Printf("Could not write disk cache: %v", err)
+	}
+	return resp, nil
+}
+
+// get returns the cached response for key, or nil if there is none.
+func (cache *diskCache) get(key string) (*CofactResponse, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.index[key]
+	if !ok || cache.isExpired(entry) {
+		return nil, nil
+	}
+	record, err := cache.read(entry)
+	if err != nil {
+		return nil, err
+	}
+	return record.Resp, nil
+}
+
+func (cache *diskCache) read(entry diskCacheIndexEntry) (*diskCacheRecord, error) {
+	buf := make([]byte, entry.length)
+	if _, err := cache.file.ReadAt(buf, entry.offset); err != nil {
+		return nil, err
+	}
+	var record diskCacheRecord
+	if err := json.Unmarshal(buf, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (cache *diskCache) put(key string, resp *CofactResponse) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	record := diskCacheRecord{
+		Version: cacheSchemaVersion,
+		Key:     key,
+		Time:    time.Now(),
+		Resp:    resp,
+	}
+	offset, length, err := cache.append(record)
+	if err != nil {
+		return err
+	}
+	cache.index[key] = diskCacheIndexEntry{
+		offset:   offset,
+		length:   length,
+		time:     record.Time,
+		articles: len(resp.Data.ListArticles.Edges),
+	}
+
+	if cache.size > cache.MaxSize {
+		return cache.compact()
+	}
+	return nil
+}
+
+// append writes a record to the end of the file. It must be called with
+// cache.mu held.
+func (cache *diskCache) append(record diskCacheRecord) (int64, int, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return 0, 0, err
+	}
+	line = append(line, '\n')
+	offset := cache.size
+	if _, err := cache.file.WriteAt(line, offset); err != nil {
+		return 0, 0, err
+	}
+	cache.size += int64(len(line))
+	return offset, len(line), nil
+}
+
+// entriesByAge returns the keys in the index, newest first.
+func (cache *diskCache) entriesByAge() []string {
+	keys := make([]string, 0, len(cache.index))
+	for key := range cache.index {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := cache.index[keys[i]], cache.index[keys[j]]
+		if a.time.Equal(b.time) {
+			return a.offset > b.offset
+		}
+		return a.time.After(b.time)
+	})
+	return keys
+}
+
+// compact rewrites the file with only the newest unexpired entries that fit
+// in half of MaxSize, leaving room to grow before the next compaction. It
+// must be called with cache.mu held.
+func (cache *diskCache) compact() error {
+	tmpPath := cache.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]diskCacheIndexEntry)
+	var size int64
+	for _, key := range cache.entriesByAge() {
+		entry := cache.index[key]
+		if cache.isExpired(entry) {
+			continue
+		}
+		if size+int64(entry.length) > cache.MaxSize/2 {
+			break
+		}
+		buf := make([]byte, entry.length)
+		if _, err := cache.file.ReadAt(buf, entry.offset); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		if _, err := tmp.Write(buf); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		entry.offset = size
+		index[key] = entry
+		size += int64(entry.length)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Windows can't rename over a file that is open, so close it first and
+	// reopen whichever file ends up at the path.
+	cache.file.Close()
+	renameErr := os.Rename(tmpPath, cache.path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+	}
+	cache.file, err = os.OpenFile(cache.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	cache.size = size
+	cache.index = index
+	return nil
+}
+
+// delete removes the entry for key by appending a tombstone.
+func (cache *diskCache) delete(key string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.index[key]; !ok {
+		return nil
+	}
+	_, _, err := cache.append(diskCacheRecord{Version: cacheSchemaVersion, Key: key, Deleted: true})
+	if err != nil {
+		return err
+	}
+	delete(cache.index, key)
+	return nil
+}
+
+// purge removes all entries.
+func (cache *diskCache) purge() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err := cache.file.Truncate(0); err != nil {
+		return err
+	}
+	cache.size = 0
+	cache.index = make(map[string]diskCacheIndexEntry)
+	return nil
+}
+
+const cacheCommandUsage = `usage: %s cache <command> [arguments]
+
+Inspects the disk cache in $CACHE_DIR. The server must be stopped first, the
+commands refuse to run while it has the cache open. Commands:
+  list          list the cached texts, newest first
+  show <text>   print the cached response for a text
+  delete <text> remove the entry for a text
+  purge         remove all entries
+  compact       rewrite the cache file without stale entries
+`
+
+// runCacheCommand implements the cache subcommand.
+func runCacheCommand(args []string) error {
+	dir := os.Getenv("CACHE_DIR")
+	if dir == "" {
+		return fmt.Errorf("$CACHE_DIR must be set")
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, cacheCommandUsage, os.Args[0])
+		return fmt.Errorf("missing cache command")
+	}
+
+	cache, err := openDiskCache(dir, nil)
+	if err == errCacheLocked {
+		return fmt.Errorf("%v, stop it before running cache commands", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	needText := func() (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("%s needs the text as argument", args[0])
+		}
+		return cacheKey(ArticleQuery{Text: args[1]}), nil
+	}
+
+	switch args[0] {
+	case "list":
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		for _, key := range cache.entriesByAge() {
+			entry := cache.index[key]
+			status := ""
+			if cache.isExpired(entry) {
+				status = " (expired)"
+			}
+			fmt.Printf("%s\t%d articles%s\t%q\n",
+				entry.time.Format(time.RFC3339), entry.articles, status, key)
+		}
+		fmt.Printf("%d entries, %d bytes\n", len(cache.index), cache.size)
+		return nil
+	case "show":
+		key, err := needText()
+		if err != nil {
+			return err
+		}
+		cache.mu.Lock()
+		entry, ok := cache.index[key]
+		if !ok {
+			cache.mu.Unlock()
+			return fmt.Errorf("no entry for %q", key)
+		}
+		record, err := cache.read(entry)
+		cache.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		out, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	case "delete":
+		key, err := needText()
+		if err != nil {
+			return err
+		}
+		return cache.delete(key)
+	case "purge":
+		return cache.purge()
+	case "compact":
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return cache.compact()
+	default:
+		fmt.Fprintf(os.Stderr, cacheCommandUsage, os.Args[0])
+		return fmt.Errorf("unknown cache command %q", args[0])
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockCacheDir opens the lock file at path and takes an exclusive lock on it,
+// without waiting. The system releases the lock when the file is closed or
+// the process exits, so a crashed server doesn't leave the cache locked.
+func lockCacheDir(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errCacheLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// The error CreateFile returns if another process has the file open.
+const errorSharingViolation syscall.Errno = 32
+
+// lockCacheDir opens the lock file at path without sharing it, which is as
+// good as an exclusive lock. Windows releases it when the file is closed or
+// the process exits, so a crashed server doesn't leave the cache locked.
+func lockCacheDir(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, errCacheLocked
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
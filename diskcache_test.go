@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := openDiskCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &CofactResponse{}
+	resp.Data.ListArticles.Edges = []Edge{{Node: Node{Id: "a"}}}
+	key := cacheKey(ArticleQuery{Text: "快轉傳"})
+	if err := server.put(key, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openDiskCache(dir, nil); err != errCacheLocked {
+		t.Errorf("opening the cache twice: got %v, want errCacheLocked", err)
+	}
+	os.Setenv("CACHE_DIR", dir)
+	defer os.Unsetenv("CACHE_DIR")
+	for _, command := range [][]string{{"purge"}, {"delete", "快轉傳"}, {"compact"}} {
+		err := runCacheCommand(command)
+		if err == nil || !strings.Contains(err.Error(), "in use") {
+			t.Errorf("cache %s while the server runs: got %v", command[0], err)
+		}
+	}
+	if got, err := server.get(key); err != nil || got == nil {
+		t.Errorf("the entry is gone: %v, %v", got, err)
+	}
+
+	// Once the server stops, the commands work
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCacheCommand([]string{"delete", "快轉傳"}); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := openDiskCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if got, _ := cache.get(key); got != nil {
+		t.Errorf("the deleted entry is still there")
+	}
+}
+
+func TestDiskCacheIgnoresOldVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := openDiskCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &CofactResponse{}
+	resp.Data.ListArticles.Edges = []Edge{{Node: Node{Id: "a"}}}
+	for i, version := range []int{cacheSchemaVersion - 1, cacheSchemaVersion} {
+		cache.mu.Lock()
+		_, _, err := cache.append(diskCacheRecord{
+			Version: version,
+			Key:     string(rune('a' + i)),
+			Time:    time.Now(),
+			Resp:    resp,
+		})
+		cache.mu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.Close()
+
+	cache, err = openDiskCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if got, _ := cache.get("a"); got != nil {
+		t.Errorf("the record of the old version was loaded")
+	}
+	if got, _ := cache.get("b"); got == nil {
+		t.Errorf("the record of the current version wasn't loaded")
+	}
+}
+
+// openTestDiskCache opens a disk cache in a new temporary directory, which
+// the returned function removes.
+func openTestDiskCache(t *testing.T, next CofactsBackend) (*diskCache, func()) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := openDiskCache(dir, next)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return cache, func() {
+		cache.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testDiskCacheResponse(articles int) *CofactResponse {
+	resp := &CofactResponse{}
+	resp.Data.ListArticles.Edges = []Edge{}
+	for i := 0; i < articles; i++ {
+		resp.Data.ListArticles.Edges = append(resp.Data.ListArticles.Edges, Edge{Node: Node{Id: fmt.Sprint(i)}})
+	}
+	return resp
+}
+
+func TestDiskCacheCompact(t *testing.T) {
+	cache, cleanup := openTestDiskCache(t, nil)
+	defer cleanup()
+
+	if err := cache.put("key00", testDiskCacheResponse(1)); err != nil {
+		t.Fatal(err)
+	}
+	// Room for about ten records, so every compaction keeps about five
+	cache.MaxSize = 10 * cache.size
+	keys := []string{"key00"}
+	for i := 1; i < 40; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		keys = append(keys, key)
+		if err := cache.put(key, testDiskCacheResponse(1)); err != nil {
+			t.Fatal(err)
+		}
+		if cache.size > cache.MaxSize {
+			t.Fatalf("after %s the file has %d bytes, more than MaxSize %d", key, cache.size, cache.MaxSize)
+		}
+		info, err := cache.file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != cache.size {
+			t.Fatalf("after %s the file has %d bytes, the cache counted %d", key, info.Size(), cache.size)
+		}
+	}
+
+	// The newest entries are kept, the oldest evicted
+	if len(cache.index) < 4 || len(cache.index) > 10 {
+		t.Errorf("%d entries left", len(cache.index))
+	}
+	for i, key := range keys {
+		got, err := cache.get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newest := i >= len(keys)-len(cache.index); (got != nil) != newest {
+			t.Errorf("%s: cached %v, want %v", key, got != nil, newest)
+		}
+	}
+
+	// Expired entries are dropped even if they fit
+	cache.TTL = time.Nanosecond
+	cache.mu.Lock()
+	err := cache.compact()
+	cache.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.index) != 0 || cache.size != 0 {
+		t.Errorf("%d entries and %d bytes left after compacting expired entries", len(cache.index), cache.size)
+	}
+	cache.TTL = defaultCacheTTL
+	if err := cache.put("after", testDiskCacheResponse(1)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.get("after"); err != nil || got == nil {
+		t.Errorf("the entry written after compacting isn't cached: %v, %v", got, err)
+	}
+}
+
+func TestDiskCacheTTL(t *testing.T) {
+	backend := &fakeBackend{Articles: testArticles}
+	cache, cleanup := openTestDiskCache(t, backend)
+	defer cleanup()
+	cache.TTL = time.Hour
+	cache.NegativeTTL = time.Minute
+
+	tests := []struct {
+		key      string
+		age      time.Duration
+		articles int
+		cached   bool
+	}{
+		{"fresh", 59 * time.Minute, 1, true},
+		{"expired", 61 * time.Minute, 1, false},
+		{"fresh negative", 59 * time.Second, 0, true},
+		{"expired negative", 61 * time.Second, 0, false},
+	}
+	queryFor := func(text string) ArticleQuery { return ArticleQuery{Text: text, First: 10} }
+	for _, test := range tests {
+		key := cacheKey(queryFor(test.key))
+		cache.mu.Lock()
+		offset, length, err := cache.append(diskCacheRecord{
+			Version: cacheSchemaVersion,
+			Key:     key,
+			Time:    time.Now().Add(-test.age),
+			Resp:    testDiskCacheResponse(test.articles),
+		})
+		cache.index[key] = diskCacheIndexEntry{
+			offset:   offset,
+			length:   length,
+			time:     time.Now().Add(-test.age),
+			articles: test.articles,
+		}
+		cache.mu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range tests {
+		got, err := cache.get(cacheKey(queryFor(test.key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got != nil) != test.cached {
+			t.Errorf("%s: cached %v, want %v", test.key, got != nil, test.cached)
+		}
+	}
+
+	// Fresh entries are served from the cache, expired ones fetched again
+	// and replaced
+	for _, test := range tests {
+		resp, err := cache.ListArticles(context.Background(), queryFor(test.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := len(testArticles)
+		if test.cached {
+			want = test.articles
+		}
+		if got := len(resp.Data.ListArticles.Edges); got != want {
+			t.Errorf("%s: got %d articles, want %d", test.key, got, want)
+		}
+	}
+	if got, _ := cache.get(cacheKey(queryFor("expired"))); got == nil || len(got.Data.ListArticles.Edges) != len(testArticles) {
+		t.Errorf("the fetched response didn't replace the expired one")
+	}
+}
+
+func TestDiskCacheTruncatedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := openDiskCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.put("complete", testDiskCacheResponse(2)); err != nil {
+		t.Fatal(err)
+	}
+	complete := cache.size
+	if err := cache.put("truncated", testDiskCacheResponse(2)); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// Cut the last record in the middle, as if we crashed writing it
+	path := filepath.Join(dir, cacheFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, complete+(info.Size()-complete)/2); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = openDiskCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.size != complete {
+		t.Errorf("loaded %d bytes, want the %d of the complete record", cache.size, complete)
+	}
+	if got, err := cache.get("complete"); err != nil || got == nil || len(got.Data.ListArticles.Edges) != 2 {
+		t.Errorf("the complete record: %v, %v", got, err)
+	}
+	if got, _ := cache.get("truncated"); got != nil {
+		t.Errorf("the truncated record was loaded")
+	}
+
+	// New records go where the partial one was
+	if err := cache.put("new", testDiskCacheResponse(1)); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+	cache, err = openDiskCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	for _, key := range []string{"complete", "new"} {
+		if got, err := cache.get(key); err != nil || got == nil {
+			t.Errorf("%s after reopening: %v, %v", key, got, err)
+		}
+	}
+}
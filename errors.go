@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The codes of APIError, which clients can rely on, unlike the messages.
+const (
+	ErrInvalidInput        = "invalid_input"
+	ErrNotFound            = "not_found"
+	ErrUpstreamUnavailable = "upstream_unavailable"
+	ErrUpstreamBadResponse = "upstream_bad_response"
+	ErrTimeout             = "timeout"
+	ErrCanceled            = "canceled"
+	ErrInternal            = "internal"
+)
+
+// The status of requests the client gave up on, as nginx logs them. The
+// client never sees it, but it keeps them apart from failures in the logs.
+const statusClientClosedRequest = 499
+
+// APIError is the body of every error response, in an object under "error",
+// and the error of an item of a batch.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Identifies the request in the logs. Taken from the X-Request-Id header
+	// that the Heroku router adds, or made up if there is none.
+	RequestId string `json:"requestId,omitempty"`
+
+	// Seconds after which it makes sense to try again, for errors that are
+	// likely temporary.
+	RetryAfter int `json:"retryAfter,omitempty"`
+
+	Status int `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// ErrorResponse is the body of an error response.
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func invalidInput(err error) *APIError {
+	return &APIError{Code: ErrInvalidInput, Message: err.Error(), Status: http.StatusBadRequest}
+}
+
+// classifyError turns an error from checking a text into an APIError, with
+// the status that tells whether the client or Cofacts is to blame.
+func classifyError(err error) *APIError {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+	apiErr := &APIError{Message: err.Error()}
+	if err == errEmptyQuery {
+		apiErr.Code, apiErr.Status = ErrInvalidInput, http.StatusBadRequest
+		return apiErr
+	}
+
+	// The http client wraps the context errors of a request in a url.Error
+	cause := err
+	if urlErr, ok := err.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+	if cause == context.Canceled {
+		apiErr.Code, apiErr.Status = ErrCanceled, statusClientClosedRequest
+		return apiErr
+	}
+	if netErr, ok := cause.(net.Error); (ok && netErr.Timeout()) || cause == context.DeadlineExceeded {
+		apiErr.Code, apiErr.Status, apiErr.RetryAfter = ErrTimeout, http.StatusGatewayTimeout, 5
+		return apiErr
+	}
+
+	switch err := err.(type) {
+	case *UpstreamStatusError:
+		// The body of the response stays in the logs, it may be long and
+		// isn't meant for our clients
+		apiErr.Message = fmt.Sprintf("cofacts returned %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+		if err.StatusCode == http.StatusTooManyRequests || err.StatusCode >= 500 {
+			apiErr.Code, apiErr.Status, apiErr.RetryAfter = ErrUpstreamUnavailable, http.StatusBadGateway, 30
+		} else {
+			apiErr.Code, apiErr.Status = ErrUpstreamBadResponse, http.StatusBadGateway
+		}
+	case GraphQLErrors, *json.SyntaxError, *json.UnmarshalTypeError:
+		apiErr.Code, apiErr.Status = ErrUpstreamBadResponse, http.StatusBadGateway
+	case *url.Error, net.Error:
+		// Cofacts couldn't be reached, or the connection broke
+		apiErr.Code, apiErr.Status, apiErr.RetryAfter = ErrUpstreamUnavailable, http.StatusBadGateway, 10
+	default:
+		if err == errCofactsResponseTooLarge {
+			apiErr.Code, apiErr.Status = ErrUpstreamBadResponse, http.StatusBadGateway
+		} else {
+			apiErr.Code, apiErr.Status = ErrInternal, http.StatusInternalServerError
+		}
+	}
+	log.Printf("Could not check text: %v", err)
+	return apiErr
+}
+
+const requestIdKey = "requestId"
+
+// handleRequestId makes sure every request has an id, and returns it in the
+// X-Request-Id header.
+func handleRequestId(c *gin.Context) {
+	id := c.GetHeader("X-Request-Id")
+	if id == "" {
+		var b [8]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+	}
+	c.Set(requestIdKey, id)
+	c.Header("X-Request-Id", id)
+	c.Next()
+}
+
+// withRequestId returns a copy of the error with the id of the request.
+func withRequestId(c *gin.Context, apiErr *APIError) *APIError {
+	withId := *apiErr
+	withId.RequestId = c.GetString(requestIdKey)
+	return &withId
+}
+
+func respondError(c *gin.Context, apiErr *APIError) {
+	apiErr = withRequestId(c, apiErr)
+	if apiErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	c.AbortWithStatusJSON(apiErr.Status, ErrorResponse{Error: apiErr})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error like the one the http client returns when its
+// Timeout passes.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Post", URL: defaultCofactsEndpoint, Err: err}
+	}
+	tests := []struct {
+		name       string
+		err        error
+		code       string
+		status     int
+		retryAfter int
+	}{
+		{"empty query", errEmptyQuery, ErrInvalidInput, http.StatusBadRequest, 0},
+		{"api error", invalidInput(errors.New("bad")), ErrInvalidInput, http.StatusBadRequest, 0},
+		{"cancelled", context.Canceled, ErrCanceled, statusClientClosedRequest, 0},
+		{"cancelled request", urlError(context.Canceled), ErrCanceled, statusClientClosedRequest, 0},
+		{"deadline", context.DeadlineExceeded, ErrTimeout, http.StatusGatewayTimeout, 5},
+		{"request past deadline", urlError(context.DeadlineExceeded), ErrTimeout, http.StatusGatewayTimeout, 5},
+		{"client timeout", urlError(timeoutError{}), ErrTimeout, http.StatusGatewayTimeout, 5},
+		{"network timeout", &net.OpError{Op: "read", Err: timeoutError{}}, ErrTimeout, http.StatusGatewayTimeout, 5},
+		{"connection refused", urlError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}),
+			ErrUpstreamUnavailable, http.StatusBadGateway, 10},
+		{"connection closed", urlError(io.EOF), ErrUpstreamUnavailable, http.StatusBadGateway, 10},
+		{"rate limited", &UpstreamStatusError{StatusCode: http.StatusTooManyRequests},
+			ErrUpstreamUnavailable, http.StatusBadGateway, 30},
+		{"upstream down", &UpstreamStatusError{StatusCode: http.StatusServiceUnavailable},
+			ErrUpstreamUnavailable, http.StatusBadGateway, 30},
+		{"upstream rejects query", &UpstreamStatusError{StatusCode: http.StatusBadRequest},
+			ErrUpstreamBadResponse, http.StatusBadGateway, 0},
+		{"graphql error", GraphQLErrors{{Message: "Cannot query field"}},
+			ErrUpstreamBadResponse, http.StatusBadGateway, 0},
+		{"invalid json", &json.SyntaxError{}, ErrUpstreamBadResponse, http.StatusBadGateway, 0},
+		{"response too large", errCofactsResponseTooLarge, ErrUpstreamBadResponse, http.StatusBadGateway, 0},
+		{"bug", errors.New("nil map"), ErrInternal, http.StatusInternalServerError, 0},
+	}
+	for _, test := range tests {
+		apiErr := classifyError(test.err)
+		if apiErr.Code != test.code || apiErr.Status != test.status || apiErr.RetryAfter != test.retryAfter {
+			t.Errorf("%s: got %s, %d, retry after %d, want %s, %d, retry after %d", test.name,
+				apiErr.Code, apiErr.Status, apiErr.RetryAfter, test.code, test.status, test.retryAfter)
+		}
+	}
+}
+
+// TestClassifyClientErrors classifies the errors the Cofacts client really
+// returns when a request is cancelled or takes too long.
+func TestClassifyClientErrors(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newCofactsClient(server.URL, "", defaultCofactsTimeout)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := client.ListArticles(ctx, ArticleQuery{Text: "a"})
+	if apiErr := classifyError(err); apiErr.Code != ErrCanceled {
+		t.Errorf("cancelled request: %v classified as %s", err, apiErr.Code)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.ListArticles(ctx, ArticleQuery{Text: "a"})
+	if apiErr := classifyError(err); apiErr.Code != ErrTimeout {
+		t.Errorf("request past its deadline: %v classified as %s", err, apiErr.Code)
+	}
+
+	client = newCofactsClient(server.URL, "", 20*time.Millisecond)
+	_, err = client.ListArticles(context.Background(), ArticleQuery{Text: "a"})
+	if apiErr := classifyError(err); apiErr.Code != ErrTimeout {
+		t.Errorf("request past the client timeout: %v classified as %s", err, apiErr.Code)
+	}
+
+	client = newCofactsClient("http://127.0.0.1:1", "", defaultCofactsTimeout)
+	_, err = client.ListArticles(context.Background(), ArticleQuery{Text: "a"})
+	if apiErr := classifyError(err); apiErr.Code != ErrUpstreamUnavailable {
+		t.Errorf("unreachable upstream: %v classified as %s", err, apiErr.Code)
+	}
+}
+
+// TestClassifyUpstreamResponses classifies the errors the Cofacts client
+// returns for responses it can't use, which must not pass on the body.
+func TestClassifyUpstreamResponses(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("Service Unavailable ", 1000) + "</body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, page)
+		case "/huge":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"data": {"ListArticles": {"edges": [], "padding": "`)
+			w.Write(bytes.Repeat([]byte("a"), maxCofactsResponseSize))
+			io.WriteString(w, `"}}}`)
+		}
+	}))
+	defer server.Close()
+
+	client := newCofactsClient(server.URL+"/down", "", defaultCofactsTimeout)
+	_, err := client.ListArticles(context.Background(), ArticleQuery{Text: "a"})
+	statusErr, ok := err.(*UpstreamStatusError)
+	if !ok || statusErr.StatusCode != http.StatusServiceUnavailable || statusErr.Body != page[:maxErrorBodySize] {
+		t.Fatalf("error page: got %v", err)
+	}
+	apiErr := classifyError(err)
+	if apiErr.Code != ErrUpstreamUnavailable || apiErr.Message != "cofacts returned 503 Service Unavailable" {
+		t.Errorf("error page: classified as %s: %q", apiErr.Code, apiErr.Message)
+	}
+
+	client = newCofactsClient(server.URL+"/huge", "", defaultCofactsTimeout)
+	_, err = client.ListArticles(context.Background(), ArticleQuery{Text: "a"})
+	if err != errCofactsResponseTooLarge {
+		t.Fatalf("huge response: got %v", err)
+	}
+	if apiErr := classifyError(err); apiErr.Code != ErrUpstreamBadResponse {
+		t.Errorf("huge response: classified as %s", apiErr.Code)
+	}
+}
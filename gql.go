@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+)
+
+//go:generate go run ./tools/gqlgen -schema cofacts_schema.json -out cofacts_schema_gen.go
+
+// gqlFieldDef describes a field of a type in the Cofacts schema, see
+// gqlSchema in cofacts_schema_gen.go.
+type gqlFieldDef struct {
+	// The name of the type of the field, without list or non-null wrappers.
+	Type string
+	List bool
+	Args []string
+}
+
+func (def gqlFieldDef) hasArg(name string) bool {
+	for _, a := range def.Args {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGqlQuery checks that every field and argument in the selection
+// of a query exists in the schema, and that fields of object types have a
+// selection while scalars don't. It only understands the subset of GraphQL
+// this server writes: a single operation without fragments or directives.
+func validateGqlQuery(query string) error {
+	tokens := gqlTokenize(query)
+	pos := 0
+	// Skip the operation type, name and variable definitions
+	for pos < len(tokens) && tokens[pos] != "{" {
+		if tokens[pos] == "(" {
+			pos = skipGqlParens(tokens, pos)
+			continue
+		}
+		pos++
+	}
+	if pos == len(tokens) {
+		return fmt.Errorf("graphql query has no selection")
+	}
+	pos, err := validateGqlSelection(tokens, pos, gqlQueryType)
+	if err != nil {
+		return err
+	}
+	if pos != len(tokens) {
+		return fmt.Errorf("graphql query has %q after the selection", tokens[pos])
+	}
+	return nil
+}
+
+// validateGqlSelection checks the selection set starting at the "{" at pos
+// against the fields of typeName, and returns the position after its "}".
+func validateGqlSelection(tokens []string, pos int, typeName string) (int, error) {
+	fields, ok := gqlSchema[typeName]
+	if !ok {
+		return pos, fmt.Errorf("graphql type %s has no fields", typeName)
+	}
+	pos++ // {
+	for pos < len(tokens) && tokens[pos] != "}" {
+		name := tokens[pos]
+		def, ok := fields[name]
+		if !ok {
+			return pos, fmt.Errorf("graphql type %s has no field %q", typeName, name)
+		}
+		pos++
+
+		if pos < len(tokens) && tokens[pos] == "(" {
+			end := skipGqlParens(tokens, pos)
+			// Argument names are the names followed by a colon at the top
+			// level of the parentheses.
+			depth := 0
+			for i := pos + 1; i < end-1; i++ {
+				switch tokens[i] {
+				case "(", "[", "{":
+					depth++
+				case ")", "]", "}":
+					depth--
+				case ":":
+					if depth == 0 && !def.hasArg(tokens[i-1]) {
+						return pos, fmt.Errorf("graphql field %s.%s has no argument %q",
+							typeName, name, tokens[i-1])
+					}
+				}
+			}
+			pos = end
+		}
+
+		_, isObject := gqlSchema[def.Type]
+		hasSelection := pos < len(tokens) && tokens[pos] == "{"
+		if isObject && !hasSelection {
+			return pos, fmt.Errorf("graphql field %s.%s of type %s needs a selection",
+				typeName, name, def.Type)
+		}
+		if !isObject && hasSelection {
+			return pos, fmt.Errorf("graphql field %s.%s of type %s can't have a selection",
+				typeName, name, def.Type)
+		}
+		if hasSelection {
+			var err error
+			if pos, err = validateGqlSelection(tokens, pos, def.Type); err != nil {
+				return pos, err
+			}
+		}
+	}
+	if pos == len(tokens) {
+		return pos, fmt.Errorf("graphql selection of %s is not closed", typeName)
+	}
+	return pos + 1, nil
+}
+
+// skipGqlParens returns the position after the ")" that closes the "(" at pos.
+func skipGqlParens(tokens []string, pos int) int {
+	depth := 0
+	for ; pos < len(tokens); pos++ {
+		switch tokens[pos] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return pos + 1
+			}
+		}
+	}
+	return pos
+}
+
+// gqlTokenize splits a query into names, punctuation and string literals.
+// Commas are insignificant in GraphQL and are dropped, like whitespace.
+func gqlTokenize(query string) []string {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '"':
+			start := i
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(runes) {
+				i = len(runes) - 1
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		case r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.':
+			start := i
+			for i+1 < len(runes) && (runes[i+1] == '_' || unicode.IsLetter(runes[i+1]) ||
+				unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		default:
+			tokens = append(tokens, string(r))
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateGqlQuery(t *testing.T) {
+	if err := validateGqlQuery(cofactsGqlQuery); err != nil {
+		t.Fatalf("cofactsGqlQuery: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		err   string // part of the error, empty if the query is valid
+	}{
+		{`query { ListArticles { totalCount } }`, ""},
+		{`query($id: String!) { GetArticle(id: $id) { id, text } }`, ""},
+		{`{ ListArticles(filter: { moreLikeThis: { like: "a(b" } }, first: 1) { totalCount } }`, ""},
+		{`# a comment with { braces
+		query { ListArticles { totalCount } }`, ""},
+		{`query { ListArticles { edges { node { id textt } } } }`, `type Article has no field "textt"`},
+		{`query { ListArticles { edges { node { articleReplies { reply { id } feedback } } } } }`,
+			`type ArticleReply has no field "feedback"`},
+		{`query { ListArticles(frist: 1) { totalCount } }`, `has no argument "frist"`},
+		{`query { ListArticles { edges { node { hyperlinks } } } }`, "needs a selection"},
+		{`query { ListArticles { totalCount { value } } }`, "can't have a selection"},
+		{`query { ListArticles { totalCount }`, "is not closed"},
+		{`query { ListArticles { totalCount } } }`, "after the selection"},
+		{`query`, "has no selection"},
+	}
+	for _, test := range tests {
+		err := validateGqlQuery(test.query)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.query, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got error %v, want %q", test.query, err, test.err)
+		}
+	}
+}
+
+// gqlSelection holds the fields selected from an object, each with the
+// fields selected from it in turn, or nil for scalars.
+type gqlSelection map[string]gqlSelection
+
+// parseGqlSelection parses the selection set starting at the "{" at pos, and
+// returns the position after its "}".
+func parseGqlSelection(tokens []string, pos int) (gqlSelection, int) {
+	selection := gqlSelection{}
+	pos++ // {
+	for pos < len(tokens) && tokens[pos] != "}" {
+		name := tokens[pos]
+		pos++
+		if pos < len(tokens) && tokens[pos] == "(" {
+			pos = skipGqlParens(tokens, pos)
+		}
+		selection[name] = nil
+		if pos < len(tokens) && tokens[pos] == "{" {
+			selection[name], pos = parseGqlSelection(tokens, pos)
+		}
+	}
+	return selection, pos + 1
+}
+
+// The Go kinds that the scalar types of the schema decode into. Enums
+// decode into strings too.
+var gqlScalarKinds = map[string]reflect.Kind{
+	"ID":      reflect.String,
+	"String":  reflect.String,
+	"Int":     reflect.Int,
+	"Float":   reflect.Float64,
+	"Boolean": reflect.Bool,
+}
+
+// The fields of the structs that the server adds, and that Cofacts doesn't
+// know about.
+var serverAddedFields = map[string]bool{
+	"Node.ismatch":  true,
+	"Node.score":    true,
+	"Node.strategy": true,
+	"Node.match":    true,
+	"Node.signals":  true,
+	"Node.warnings": true,
+	"Node.verdict":  true,
+}
+
+// TestCofactsStructsMatchSchema walks the json tags of the structs that
+// Cofacts responses are decoded into, and checks that each field exists in
+// the schema with a matching type and is asked for by cofactsGqlQuery, so
+// it doesn't silently stay empty. It also checks that everything the query
+// asks for is decoded.
+func TestCofactsStructsMatchSchema(t *testing.T) {
+	tokens := gqlTokenize(cofactsGqlQuery)
+	pos := 0
+	for pos < len(tokens) && tokens[pos] != "{" {
+		if tokens[pos] == "(" {
+			pos = skipGqlParens(tokens, pos)
+			continue
+		}
+		pos++
+	}
+	selection, _ := parseGqlSelection(tokens, pos)
+	checkStructAgainstSchema(t, reflect.TypeOf(Data{}), gqlQueryType, selection, "data")
+}
+
+func checkStructAgainstSchema(t *testing.T, goType reflect.Type, typeName string, selection gqlSelection, path string) {
+	decoded := make(map[string]bool)
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			t.Errorf("%s.%s has no json name", goType.Name(), field.Name)
+			continue
+		}
+		if serverAddedFields[goType.Name()+"."+name] {
+			continue
+		}
+		fieldPath := path + "." + name
+		def, ok := gqlSchema[typeName][name]
+		if !ok {
+			t.Errorf("%s: %s.%s decodes %s.%s, which isn't in the schema",
+				fieldPath, goType.Name(), field.Name, typeName, name)
+			continue
+		}
+		decoded[name] = true
+
+		fieldType := field.Type
+		if def.List != (fieldType.Kind() == reflect.Slice) {
+			t.Errorf("%s: %s.%s is a %s, but the schema has a list %v", fieldPath, goType.Name(), field.Name,
+				fieldType, def.List)
+			continue
+		}
+		if def.List {
+			fieldType = fieldType.Elem()
+		}
+
+		sub, selected := selection[name]
+		if !selected {
+			t.Errorf("%s: cofactsGqlQuery doesn't ask for %s.%s", fieldPath, typeName, name)
+		}
+		if _, isObject := gqlSchema[def.Type]; isObject {
+			if fieldType.Kind() != reflect.Struct {
+				t.Errorf("%s: %s.%s is a %s, but %s is an object", fieldPath, goType.Name(), field.Name,
+					fieldType, def.Type)
+				continue
+			}
+			checkStructAgainstSchema(t, fieldType, def.Type, sub, fieldPath)
+			continue
+		}
+		kind, ok := gqlScalarKinds[def.Type]
+		if !ok && strings.HasSuffix(def.Type, "Enum") {
+			kind, ok = reflect.String, true
+		}
+		if !ok || fieldType.Kind() != kind {
+			t.Errorf("%s: %s.%s is a %s, which %s doesn't decode into", fieldPath, goType.Name(), field.Name,
+				fieldType, def.Type)
+		}
+	}
+
+	for name := range selection {
+		if !decoded[name] {
+			t.Errorf("%s.%s: cofactsGqlQuery asks for %s.%s, but %s doesn't decode it",
+				path, name, typeName, name, goType.Name())
+		}
+	}
+}
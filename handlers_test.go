@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeBackend answers every query with the same articles, after Delay, or
+// with the error in Errors for the text.
+type fakeBackend struct {
+	Articles []Node
+	Errors   map[string]error
+	Delay    time.Duration
+}
+
+func (backend *fakeBackend) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	if backend.Delay > 0 {
+		select {
+		case <-time.After(backend.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err, ok := backend.Errors[query.Text]; ok {
+		return nil, err
+	}
+
+	start := 0
+	if query.After != "" {
+		start, _ = strconv.Atoi(query.After)
+	}
+	resp := &CofactResponse{}
+	resp.Data.ListArticles.Edges = []Edge{}
+	for i := start; i < len(backend.Articles) && i < start+query.First; i++ {
+		resp.Data.ListArticles.Edges = append(resp.Data.ListArticles.Edges, Edge{
+			Node:   backend.Articles[i],
+			Cursor: strconv.Itoa(i + 1),
+		})
+	}
+	resp.Data.ListArticles.PageInfo.LastCursor = strconv.Itoa(len(backend.Articles))
+	return resp, nil
+}
+
+var testArticles = []Node{
+	{
+		Id:         "rumor",
+		Text:       "緊急通知！喝溫開水可以預防新型冠狀病毒，請大家轉傳給親朋好友。",
+		Hyperlinks: []Hyperlink{},
+		ArticleReplies: []ArticleReplies{{
+			Status:                "NORMAL",
+			PositiveFeedbackCount: 3,
+			Reply:                 ArticleReply{Id: "r1", Text: "喝水無法預防病毒。", Type: ReplyRumor},
+		}},
+	},
+	{
+		Id:             "weather",
+		Text:           "今天天氣很好，我們去公園散步吧",
+		Hyperlinks:     []Hyperlink{},
+		ArticleReplies: []ArticleReplies{},
+	},
+}
+
+// newTestRouter returns the server's router answering from backend. The
+// returned function restores the real backend.
+func newTestRouter(backend CofactsBackend) (*gin.Engine, func()) {
+	gin.SetMode(gin.TestMode)
+	saved := cofacts
+	cofacts = backend
+	return newRouter(), func() { cofacts = saved }
+}
@@ -0,0 +1,109 @@
+package main
+
+// A suffix automaton recognises all substrings of the text it was built from,
+// using at most 2n states. Walking the second text through the automaton of
+// the first gives their longest common substring in O(n+m) time, instead of
+// the O(n*m) of the dynamic programming approach.
+// See https://cp-algorithms.com/string/suffix-automaton.html
+type samState struct {
+	len  int
+	link int
+	next map[rune]int
+
+	// End position in the text of the first occurrence of this state's
+	// substrings, used to find where a match is located.
+	firstEnd int
+}
+
+type suffixAutomaton struct {
+	states []samState
+	last   int
+}
+
+func newSuffixAutomaton(text []rune) *suffixAutomaton {
+	sam := &suffixAutomaton{
+		states: make([]samState, 1, 2*len(text)+1),
+	}
+	sam.states[0] = samState{link: -1, next: make(map[rune]int), firstEnd: -1}
+	for i, r := range text {
+		sam.extend(r, i)
+	}
+	return sam
+}
+
+func (sam *suffixAutomaton) extend(r rune, pos int) {
+	cur := len(sam.states)
+	sam.states = append(sam.states, samState{
+		len:      sam.states[sam.last].len + 1,
+		next:     make(map[rune]int),
+		firstEnd: pos,
+	})
+
+	p := sam.last
+	for p != -1 {
+		if _, ok := sam.states[p].next[r]; ok {
+			break
+		}
+		sam.states[p].next[r] = cur
+		p = sam.states[p].link
+	}
+
+	if p == -1 {
+		sam.states[cur].link = 0
+	} else {
+		q := sam.states[p].next[r]
+		if sam.states[p].len+1 == sam.states[q].len {
+			sam.states[cur].link = q
+		} else {
+			clone := len(sam.states)
+			next := make(map[rune]int, len(sam.states[q].next))
+			for k, v := range sam.states[q].next {
+				next[k] = v
+			}
+			sam.states = append(sam.states, samState{
+				len:      sam.states[p].len + 1,
+				link:     sam.states[q].link,
+				next:     next,
+				firstEnd: sam.states[q].firstEnd,
+			})
+			for p != -1 && sam.states[p].next[r] == q {
+				sam.states[p].next[r] = clone
+				p = sam.states[p].link
+			}
+			sam.states[q].link = clone
+			sam.states[cur].link = clone
+		}
+	}
+	sam.last = cur
+}
+
+// longestCommonSubstring returns the start of the longest common substring in
+// both a and b, and its length in runes. If there are several, the first one
+// in b is returned. The length is 0 if a and b have nothing in common.
+func longestCommonSubstring(a []rune, b []rune) (aStart int, bStart int, length int) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, 0, 0
+	}
+
+	sam := newSuffixAutomaton(a)
+	state, l := 0, 0
+	for i, r := range b {
+		for state != 0 {
+			if _, ok := sam.states[state].next[r]; ok {
+				break
+			}
+			state = sam.states[state].link
+			l = sam.states[state].len
+		}
+		if next, ok := sam.states[state].next[r]; ok {
+			state = next
+			l++
+		}
+		if l > length {
+			length = l
+			aStart = sam.states[state].firstEnd - l + 1
+			bStart = i - l + 1
+		}
+	}
+	return aStart, bStart, length
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"gopkg.in/vmarkovtsev/go-lcss.v1"
+)
+
+// The byte-based implementation longestCommonSubstring replaced, kept as the
+// baseline for the benchmarks.
+func chunk(s []byte, chunkSize int) [][]byte {
+	var chunks [][]byte
+
+	if len(s) == 0 {
+		return make([][]byte, 0)
+	}
+
+	for i := 0; i < len(s); i += chunkSize {
+		nn := i + chunkSize
+		if nn > len(s) {
+			nn = len(s)
+		}
+		chunks = append(chunks, s[i:nn])
+	}
+	return chunks
+}
+
+func lcss_chunked(a []byte, b []byte) []byte {
+	if len(a) > len(b) {
+		return lcss_chunked(b, a)
+	}
+
+	if len(a)*6 > len(b) {
+		return lcss.LongestCommonSubstring(a, b)
+	}
+
+	var best []byte = make([]byte, 0)
+	var best_len int = 0
+
+	chunks := chunk(b, 2*len(a))
+	for _, chunk := range chunks {
+		current := lcss.LongestCommonSubstring(a, chunk)
+		if len(current) > best_len {
+			best = current
+			best_len = len(current)
+		}
+	}
+
+	chunks = chunk(b[len(a):], 2*len(a))
+	for _, chunk := range chunks {
+		current := lcss.LongestCommonSubstring(a, chunk)
+		if len(current) > best_len {
+			best = current
+			best_len = len(current)
+		}
+	}
+
+	return best
+}
+
+// bruteForceLcs is the obvious O(n*m*l) algorithm, returning the first
+// longest common substring in b like longestCommonSubstring does.
+func bruteForceLcs(a []rune, b []rune) (int, int, int) {
+	bestA, bestB, best := 0, 0, 0
+	for j := range b {
+		for i := range a {
+			l := 0
+			for i+l < len(a) && j+l < len(b) && a[i+l] == b[j+l] {
+				l++
+			}
+			if l > best {
+				bestA, bestB, best = i, j, l
+			}
+		}
+	}
+	return bestA, bestB, best
+}
+
+func TestLongestCommonSubstring(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"abc", "", ""},
+		{"abc", "xyz", ""},
+		{"abcdef", "zcdez", "cde"},
+		{"請不要轉傳這則訊息", "這則訊息是假的", "這則訊息"},
+		{"aaaa", "aa", "aa"},
+		{"abab", "baba", "bab"},
+	}
+	for _, test := range tests {
+		a, b := []rune(test.a), []rune(test.b)
+		aStart, bStart, length := longestCommonSubstring(a, b)
+		if got := string(b[bStart : bStart+length]); got != test.want {
+			t.Errorf("longestCommonSubstring(%q, %q) = %q, want %q", test.a, test.b, got, test.want)
+		}
+		if string(a[aStart:aStart+length]) != string(b[bStart:bStart+length]) {
+			t.Errorf("longestCommonSubstring(%q, %q): %q in a and %q in b differ", test.a, test.b,
+				string(a[aStart:aStart+length]), string(b[bStart:bStart+length]))
+		}
+	}
+}
+
+func TestLongestCommonSubstringRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []rune("ab中文😀")
+	random := func(n int) []rune {
+		r := make([]rune, n)
+		for i := range r {
+			r[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return r
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := random(rng.Intn(30)), random(rng.Intn(30))
+		aStart, bStart, length := longestCommonSubstring(a, b)
+		_, wantB, want := bruteForceLcs(a, b)
+		if length != want {
+			t.Fatalf("longestCommonSubstring(%q, %q) has length %d, want %d",
+				string(a), string(b), length, want)
+		}
+		if length > 0 && bStart != wantB {
+			t.Fatalf("longestCommonSubstring(%q, %q) starts at %d in b, want the first at %d",
+				string(a), string(b), bStart, wantB)
+		}
+		if string(a[aStart:aStart+length]) != string(b[bStart:bStart+length]) {
+			t.Fatalf("longestCommonSubstring(%q, %q) returned different substrings", string(a), string(b))
+		}
+	}
+}
+
+// benchmarkTexts returns a short query and a long article of several
+// kilobytes that contains it, like a forwarded chain message.
+func benchmarkTexts() (string, string) {
+	rng := rand.New(rand.NewSource(2))
+	words := strings.Fields("衛生 福利部 提醒 民眾 不要 轉傳 未經 證實 訊息 疫苗 副作用 " +
+		"政府 補助 申請 連結 點擊 領取 covid vaccine free gift link")
+	var b strings.Builder
+	for b.Len() < 8000 {
+		b.WriteString(words[rng.Intn(len(words))])
+		b.WriteString(" ")
+	}
+	article := b.String()
+	query := string([]rune(article)[1500:1800])
+	return query, article
+}
+
+func BenchmarkLongestCommonSubstring(b *testing.B) {
+	query, article := benchmarkTexts()
+	a, c := []rune(removeWhitespace(newNormalizedText(query)).String()),
+		[]rune(removeWhitespace(newNormalizedText(article)).String())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		longestCommonSubstring(a, c)
+	}
+}
+
+func BenchmarkLcssChunked(b *testing.B) {
+	query, article := benchmarkTexts()
+	a, c := []byte(removeWhitespace(newNormalizedText(query)).String()),
+		[]byte(removeWhitespace(newNormalizedText(article)).String())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lcss_chunked(a, c)
+	}
+}
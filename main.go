@@ -1,46 +1,60 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	_ "github.com/heroku/x/hmetrics/onload"
-	"gopkg.in/vmarkovtsev/go-lcss.v1"
 	"mvdan.cc/xurls/v2"
 )
 
 const DEBUG = false
 
 const cofactsGqlQuery = `
-query($text: String) {
+query($text: String, $first: Int, $after: String) {
   ListArticles(
 	filter: { moreLikeThis: { like: $text } }
 	orderBy: [{ _score: DESC }]
-	first: 4
+	first: $first
+	after: $after
   ) {
+	pageInfo {
+	  lastCursor
+	}
 	edges {
+	  cursor
 	  node {
 		id
 		text
+		createdAt
+		replyCount
+		replyRequestCount
 		hyperlinks {
 		  url
 		}
 		articleReplies {
+		  status
+		  createdAt
+		  positiveFeedbackCount
+		  negativeFeedbackCount
 		  reply {
 			id
 			text
 			type
 			reference
+			createdAt
 		  }
 		}
 	  }
@@ -48,7 +62,9 @@ query($text: String) {
   }
 }`
 
-// TODO: get the whole Schema from https://cofacts-api.g0v.tw/graphql
+// The structs below are the parts of the Cofacts schema the server uses, with
+// the fields the server adds. cofactsGqlQuery is checked against the schema
+// in cofacts_schema.json on startup, see validateGqlQuery.
 type Hyperlink struct {
 	Url string `json:"url"`
 }
@@ -58,31 +74,94 @@ type ArticleReply struct {
 	Text      string `json:"text"`
 	Type      string `json:"type"`
 	Reference string `json:"reference"`
+	CreatedAt string `json:"createdAt"`
 }
 
 type ArticleReplies struct {
-	Reply ArticleReply `json:"reply"`
+	Reply                 ArticleReply `json:"reply"`
+	Status                string       `json:"status"`
+	CreatedAt             string       `json:"createdAt"`
+	PositiveFeedbackCount int          `json:"positiveFeedbackCount"`
+	NegativeFeedbackCount int          `json:"negativeFeedbackCount"`
 }
 
 type Node struct {
-	Id             string           `json:"id"`
-	Text           string           `json:"text"`
-	Hyperlinks     []Hyperlink      `json:"hyperlinks"`
-	ArticleReplies []ArticleReplies `json:"articleReplies"`
+	Id                string           `json:"id"`
+	Text              string           `json:"text"`
+	CreatedAt         string           `json:"createdAt"`
+	ReplyCount        int              `json:"replyCount"`
+	ReplyRequestCount int              `json:"replyRequestCount"`
+	Hyperlinks        []Hyperlink      `json:"hyperlinks"`
+	ArticleReplies    []ArticleReplies `json:"articleReplies"`
 
 	// Added by this server to indicate whether the article matches the search query.
 	// We should just filter in the final version, but for development it will be
 	// useful to see what results we get from Cofacts and whether the server accepts
 	// or rejects them.
 	IsMatch bool `json:"ismatch"`
+
+	// How well the article matches the query, from 0 to 1, the strategy that
+	// produced the score, and what exactly matched, so the extension can rank
+	// the results and highlight the overlap.
+	Score    float64           `json:"score"`
+	Strategy string            `json:"strategy"`
+	Match    *MatchExplanation `json:"match,omitempty"`
+
+	// The scores of the individual similarity measures that were computed,
+	// by name, for tuning the thresholds.
+	Signals map[string]float64 `json:"signals,omitempty"`
+
+	// Problems found while matching this article that didn't stop the
+	// request, such as hyperlinks that aren't valid urls.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// The replies to this article summarized, see addVerdicts.
+	Verdict *Verdict `json:"verdict,omitempty"`
+}
+
+func (node *Node) addWarning(warning string) {
+	for _, w := range node.Warnings {
+		if w == warning {
+			return
+		}
+	}
+	node.Warnings = append(node.Warnings, warning)
+}
+
+// MatchExplanation describes the part of the query that was found in the
+// article. For url matches it holds the pair of equivalent urls, for text
+// matches the longest common substring. Offsets count characters (Unicode
+// code points, not bytes) in the original query and article text, with the
+// end being exclusive, and are -1 if the matched part doesn't appear literally
+// in that text.
+type MatchExplanation struct {
+	QueryUrl   string `json:"queryurl,omitempty"`
+	ArticleUrl string `json:"articleurl,omitempty"`
+	Text       string `json:"text,omitempty"`
+
+	// The url canonicalization rules that were needed to match the urls,
+	// see canonicalizeUrl.
+	UrlRules []string `json:"urlrules,omitempty"`
+
+	QueryStart   int `json:"querystart"`
+	QueryEnd     int `json:"queryend"`
+	ArticleStart int `json:"articlestart"`
+	ArticleEnd   int `json:"articleend"`
 }
 
 type Edge struct {
-	Node Node `json:"node"`
+	Node   Node   `json:"node"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type PageInfo struct {
+	// The cursor of the last of all the articles, not just of this page.
+	LastCursor string `json:"lastCursor,omitempty"`
 }
 
 type ArticleList struct {
-	Edges []Edge `json:"edges"`
+	Edges    []Edge   `json:"edges"`
+	PageInfo PageInfo `json:"pageInfo"`
 }
 
 type Data struct {
@@ -91,6 +170,14 @@ type Data struct {
 
 type CofactResponse struct {
 	Data Data `json:"data"`
+
+	// The verdict of all the replies to the matching articles, to show a
+	// single badge for the query. Missing if no article with replies matches.
+	Verdict *Verdict `json:"verdict,omitempty"`
+
+	// The effective match configuration, only included when the request
+	// asks for it with the debug parameter.
+	Config *MatchConfig `json:"config,omitempty"`
 }
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
@@ -108,27 +195,148 @@ func main() {
 		}
 	}
 
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"cache":  runCacheCommand,
+			"import": runImportCommand,
+		}
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	if err := validateGqlQuery(cofactsGqlQuery); err != nil {
+		log.Fatal("cofactsGqlQuery doesn't match the Cofacts schema: ", err)
+	}
+
 	port := os.Getenv("PORT")
 
 	if port == "" {
 		log.Fatal("$PORT must be set")
 	}
 
-	router := gin.Default()
-	router.Use(gin.Logger())
-	router.LoadHTMLGlob("templates/*.tmpl.html")
-	router.Static("/static", "static")
+	if steps, ok := os.LookupEnv("NORMALIZE"); ok {
+		if err := setTextNormalizer(steps); err != nil {
+			log.Fatal("$NORMALIZE: ", err)
+		}
+	}
+	if table := os.Getenv("S2T_TABLE"); table != "" {
+		if err := loadSimplifiedToTraditional(table); err != nil {
+			log.Fatal("$S2T_TABLE: ", err)
+		}
+	}
 
-	router.Use(cors.New(cors.Config{
-		AllowMethods:    []string{"GET"},
-		AllowHeaders:    []string{"Origin", "text"},
-		ExposeHeaders:   []string{"Content-Length"},
-		AllowAllOrigins: true,
-		MaxAge:          48 * time.Hour,
-	}))
+	endpoint := os.Getenv("COFACTS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultCofactsEndpoint
+	}
+	timeout := defaultCofactsTimeout
+	if t := os.Getenv("COFACTS_TIMEOUT"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil {
+			log.Fatal("$COFACTS_TIMEOUT must be a duration: ", err)
+		}
+	}
+	cofacts = newCofactsClient(endpoint, os.Getenv("COFACTS_APP_ID"), timeout)
 
-	router.GET("/cofacts", handleCofactsRequestWithContentInHeader)
-	router.POST("/cofacts", handleCofactsRequestWithContentInBody)
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile == "" {
+		configFile = defaultConfigFile
+	}
+	config, err := loadMatchConfig(configFile)
+	if err != nil {
+		log.Fatal("Could not load config: ", err)
+	}
+	matchConfig = config
+
+	if path := os.Getenv("OFFLINE_STORE"); path != "" {
+		store, err := loadArticleStore(path)
+		if err != nil {
+			log.Fatal("Could not load offline store: ", err)
+		}
+		index, err := loadNgramIndex(indexPath(path), len(store.Articles))
+		if err != nil {
+			log.Printf("Could not load index snapshot, rebuilding: %v", err)
+			index = buildNgramIndex(store)
+			if err := index.Save(indexPath(path)); err != nil {
+				log.Printf("Could not save index snapshot: %v", err)
+			}
+		}
+		offline := newOfflineBackend(store, index)
+		offline.NearDuplicateThreshold = matchConfig.NearDuplicateThreshold
+		// The whole store gives better document frequencies than the
+		// articles that happen to be asked for
+		for _, article := range store.Articles {
+			articleCorpus.Add(article.Id, article.Text)
+		}
+		if limit := os.Getenv("OFFLINE_LIMIT"); limit != "" {
+			offline.Limit, err = strconv.Atoi(limit)
+			if err != nil {
+				log.Fatal("$OFFLINE_LIMIT must be a number: ", err)
+			}
+		}
+		log.Printf("Offline mode, answering from %d articles in %s", len(store.Articles), path)
+		cofacts = offline
+		backendName = "offline"
+	}
+
+	cacheTTL, cacheNegativeTTL := defaultCacheTTL, defaultCacheNegativeTTL
+	for env, ttl := range map[string]*time.Duration{
+		"CACHE_TTL":          &cacheTTL,
+		"CACHE_NEGATIVE_TTL": &cacheNegativeTTL,
+	} {
+		if t := os.Getenv(env); t != "" {
+			var err error
+			*ttl, err = time.ParseDuration(t)
+			if err != nil {
+				log.Fatalf("$%s must be a duration: %v", env, err)
+			}
+		}
+	}
+
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		diskCache, err := openDiskCache(dir, cofacts)
+		if err != nil {
+			log.Fatal("Could not open disk cache: ", err)
+		}
+		defer diskCache.Close()
+		if size := os.Getenv("CACHE_DIR_MAX_SIZE"); size != "" {
+			diskCache.MaxSize, err = strconv.ParseInt(size, 10, 64)
+			if err != nil {
+				log.Fatal("$CACHE_DIR_MAX_SIZE must be a number of bytes: ", err)
+			}
+		}
+		diskCache.TTL, diskCache.NegativeTTL = cacheTTL, cacheNegativeTTL
+		cofacts = diskCache
+	}
+
+	cacheSize := defaultCacheSize
+	if size := os.Getenv("CACHE_SIZE"); size != "" {
+		var err error
+		cacheSize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal("$CACHE_SIZE must be a number: ", err)
+		}
+	}
+	if cacheSize > 0 {
+		responseCache = newCachedBackend(cofacts, cacheSize)
+		responseCache.TTL, responseCache.NegativeTTL = cacheTTL, cacheNegativeTTL
+		responseCache.Timeout = timeout
+		cofacts = responseCache
+	}
+
+	if n := os.Getenv("BATCH_CONCURRENCY"); n != "" {
+		batchConcurrency, err = strconv.Atoi(n)
+		if err != nil || batchConcurrency < 1 {
+			log.Fatal("$BATCH_CONCURRENCY must be a positive number: ", n)
+		}
+	}
+
+	router := newRouter()
 
 	if DEBUG {
 		srv := &http.Server{
@@ -147,120 +355,169 @@ func main() {
 	}
 }
 
-func isEquivalent(url1 string, url2 string) bool {
-	u1, err := url.Parse(url1)
+// newRouter sets up the middleware and the routes of the server.
+func newRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(gin.Logger())
+	router.Use(handleRequestId)
+	router.LoadHTMLGlob("templates/*.tmpl.html")
+	router.Static("/static", "static")
+
+	router.Use(cors.New(cors.Config{
+		AllowMethods:    []string{"GET", "POST"},
+		AllowHeaders:    []string{"Origin", "Content-Type", "text"},
+		ExposeHeaders:   []string{"Content-Length", "Retry-After", "X-Request-Id"},
+		AllowAllOrigins: true,
+		MaxAge:          48 * time.Hour,
+	}))
+
+	// The /cofacts routes return the Cofacts response with the match results
+	// added, and are kept for older versions of the extension. New clients
+	// should use /v2/check.
+	router.GET("/cofacts", handleCofactsRequestWithContentInHeader)
+	router.POST("/cofacts", handleCofactsRequestWithContentInBody)
+	router.POST("/cofacts/batch", handleCofactsBatch)
+	router.POST("/cofacts/stream", handleCofactsStreamPost)
+	router.GET("/cofacts/stream", handleCofactsStream)
+	router.GET("/stats", handleStats)
+	router.GET("/openapi.json", handleOpenapi)
+
+	v2 := router.Group("/v2")
+	v2.POST("/check", handleCheck)
+	return router
+}
+
+// isEquivalent compares the canonical forms of two urls. The query
+// parameters of url1 must all be present in url2, but url2 may have more.
+// It also returns the canonicalization rules that were needed to make them
+// match, or an error if either url can't be parsed.
+func isEquivalent(url1 string, url2 string) (bool, []string, error) {
+	u1, err := canonicalizeUrl(url1)
 	if err != nil {
-		panic(err)
+		return false, nil, err
 	}
-	u2, err := url.Parse(url2)
+	u2, err := canonicalizeUrl(url2)
 	if err != nil {
-		panic(err)
+		return false, nil, err
 	}
 	if u1.Host != u2.Host {
-		return false
+		return false, nil, nil
 	}
-	if strings.TrimRight(u1.Path, "/") != strings.TrimRight(u2.Path, "/") {
-		return false
+	if u1.Path != u2.Path {
+		return false, nil, nil
 	}
-	q1 := u1.Query()
-	q2 := u2.Query()
-	for k, vs := range q1 {
+	for k, vs := range u1.Query {
 		for _, v1 := range vs {
 			var found = false
-			for _, v2 := range q2[k] {
+			for _, v2 := range u2.Query[k] {
 				if v1 == v2 {
 					found = true
 					break
 				}
 			}
 			if !found {
-				return false
+				return false, nil, nil
 			}
 		}
 	}
-	return true
+
+	for _, rule := range u2.Rules {
+		u1.addRule(rule)
+	}
+	return true, u1.Rules, nil
 }
 
-func exist_same_url(node *Node, request_urls []string) bool {
+type urlMatch struct {
+	RequestUrl string
+	NodeUrl    string
+	Rules      []string
+}
+
+// exist_same_url returns the first pair of equivalent urls found in the
+// article's hyperlinks and the urls from the request, or nil if there is none.
+// Urls that can't be parsed are skipped with a warning on the node, so one
+// broken hyperlink doesn't prevent the others from matching.
+func exist_same_url(node *Node, request_urls []string) *urlMatch {
 	for _, hyperlink := range node.Hyperlinks {
 		node_url := hyperlink.Url
 		for _, request_url := range request_urls {
-			if isEquivalent(node_url, request_url) {
-				return true
+			equivalent, rules, err := isEquivalent(node_url, request_url)
+			if err != nil {
+				node.addWarning(err.Error())
+				continue
+			}
+			if equivalent {
+				return &urlMatch{RequestUrl: request_url, NodeUrl: node_url, Rules: rules}
 			}
 		}
 	}
-	return false
-}
-
-func removeWhitespace(s string) string {
-	s = strings.ReplaceAll(s, "\n", "")
-	s = strings.ReplaceAll(s, "\r", "")
-	s = strings.ReplaceAll(s, "\t", "")
-	s = strings.ReplaceAll(s, " ", "")
-	return s
+	return nil
 }
 
-func chunk(s []byte, chunkSize int) [][]byte {
-	var chunks [][]byte
-
-	if len(s) == 0 {
-		return make([][]byte, 0)
+// textMatch finds the longest common substring of the normalized query and
+// article text, ignoring whitespace, and reports where it occurs in both. It
+// also returns the length of the substring in normalized characters, so it
+// can be compared with normalizedLength of the query.
+func textMatch(query string, article string) (*MatchExplanation, int) {
+	a := removeWhitespace(textNormalizer.normalize(query))
+	b := removeWhitespace(textNormalizer.normalize(article))
+	aStart, bStart, length := longestCommonSubstring(a.runes, b.runes)
+	if length == 0 {
+		return nil, 0
 	}
 
-	for i := 0; i < len(s); i += chunkSize {
-		nn := i + chunkSize
-		if nn > len(s) {
-			nn = len(s)
-		}
-		chunks = append(chunks, s[i:nn])
-	}
-	return chunks
+	// Map the first and last rune of the match back to the original text.
+	queryEnd := a.offsets[aStart+length-1] + 1
+	return &MatchExplanation{
+		Text:         string([]rune(query)[a.offsets[aStart]:queryEnd]),
+		QueryStart:   a.offsets[aStart],
+		QueryEnd:     queryEnd,
+		ArticleStart: b.offsets[bStart],
+		ArticleEnd:   b.offsets[bStart+length-1] + 1,
+	}, length
 }
 
-func lcss_chunked(a []byte, b []byte) []byte {
-	if len(a) > len(b) {
-		return lcss_chunked(b, a)
-	}
+// normalizedLength is the number of characters of the text that take part
+// in text matching, that is after normalization and without whitespace.
+func normalizedLength(text string) int {
+	return len(removeWhitespace(textNormalizer.normalize(text)).runes)
+}
 
-	if len(a)*6 > len(b) {
-		// chunking is only faster if there is a large size difference.
-		// (didn't bother to figure out the exact threshold)
-		return lcss.LongestCommonSubstring(a, b)
+// lcsScore decides whether a common substring of length characters is a
+// match for a query of queryLength characters, both counted with
+// normalizedLength. textLength is the length of the query without its urls,
+// which decides whether the query is long enough for the ratio rule, so a
+// query that is just a url or a few words only matches on LcsMinChars. The
+// score is the fraction of the query that is covered.
+func lcsScore(length int, queryLength int, textLength int, config MatchConfig) (float64, bool) {
+	if queryLength == 0 || length == 0 {
+		return 0, false
 	}
-
-	// The performance of lcss.LongestCommonSubstring seems to be quadratic,
-	// despite what the Github page says. If one string is significantly shorter
-	// than the other, then it's faster to chunk the larger string and do
-	// several calls to lcss.LongestCommonSubstring.
-	// We split the largest string in chunks twice the size of the smaller,
-	// and do this twice with the second batch offset by the length of the smaller
-	// string to account for cases where the LCSS spills over into the next chunk.
-	// So if the smaller string is 10 bytes, we chunk the larger into the following
-	// blocks: [ 0:20], [20:40], [40:60] etc,
-	//     and [10:30], [30:50], [50:70] etc.
-	var best []byte = make([]byte, 0)
-	var best_len int = 0
-
-	chunks := chunk(b, 2*len(a))
-	for _, chunk := range chunks {
-		current := lcss.LongestCommonSubstring(a, chunk)
-		if len(current) > best_len {
-			best = current
-			best_len = len(current)
-		}
+	if length > queryLength {
+		length = queryLength
+	}
+	ratio := float64(length) / float64(queryLength)
+	if length >= config.LcsMinChars {
+		return ratio, true
 	}
+	return ratio, textLength >= config.LcsMinQueryChars && ratio >= config.LcsMinRatio
+}
 
-	chunks = chunk(b[len(a):], 2*len(a))
-	for _, chunk := range chunks {
-		current := lcss.LongestCommonSubstring(a, chunk)
-		if len(current) > best_len {
-			best = current
-			best_len = len(current)
-		}
+// runeIndex is like strings.Index, but returns the offset of substr in
+// characters rather than bytes.
+func runeIndex(s string, substr string) int {
+	i := strings.Index(s, substr)
+	if i < 0 {
+		return -1
 	}
+	return utf8.RuneCountInString(s[:i])
+}
 
-	return best
+func endOffset(start int, s string) int {
+	if start < 0 {
+		return -1
+	}
+	return start + utf8.RuneCountInString(s)
 }
 
 func handleCofactsGet(c *gin.Context) {
@@ -272,7 +529,7 @@ func handleCofactsGet(c *gin.Context) {
 func handleCofactsRequestWithContentInHeader(c *gin.Context) {
 	body, err := url.QueryUnescape(c.Request.Header.Get("text"))
 	if err != nil {
-		c.String(http.StatusInternalServerError, "error:", err)
+		respondError(c, invalidInput(err))
 		return
 	}
 
@@ -282,89 +539,195 @@ func handleCofactsRequestWithContentInHeader(c *gin.Context) {
 func handleCofactsRequestWithContentInBody(c *gin.Context) {
 	body, err := c.GetRawData()
 	if err != nil {
-		c.String(http.StatusInternalServerError, "error:", err)
+		respondError(c, invalidInput(err))
 		return
 	}
 
 	handleCofacts(c, string(body))
 }
 
+func handleStats(c *gin.Context) {
+	stats := gin.H{}
+	if responseCache != nil {
+		stats["cache"] = responseCache.Stats()
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
 func handleCofacts(c *gin.Context, text string) {
-	// Call the Cofacts api
-	respText, err := callCofactsApi(text)
+	config, err := matchConfig.withOverrides(c.Request.URL.Query())
 	if err != nil {
-		c.String(http.StatusInternalServerError, "error:", err)
+		respondError(c, invalidInput(err))
 		return
 	}
 
-	// Convert to CofactResponse struct
-	var respData CofactResponse
-	err = json.Unmarshal([]byte(respText), &respData)
+	respData, err := checkText(c.Request.Context(), text, config)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "error:", err)
+		respondError(c, classifyError(err))
 		return
 	}
 
-	// Follow roughly the same filter approach as Aunt Meiyu
-	rxStrict := xurls.Strict()
-	request_urls := rxStrict.FindAllString(text, -1)
-	if len(request_urls) > 0 {
-		// If there's a url in the text, it must be in the article
-		for i := range respData.Data.ListArticles.Edges {
-			node := &respData.Data.ListArticles.Edges[i].Node
-			node.IsMatch = exist_same_url(node, request_urls)
-		}
-	} else {
-		// Todo: should use tf-idf, but for an early demo this is good enough
-		for i := range respData.Data.ListArticles.Edges {
-			node := &respData.Data.ListArticles.Edges[i].Node
-
-			// strip any whitespace for comparison
-			a := removeWhitespace(text)
-			b := removeWhitespace(node.Text)
-			common := lcss_chunked([]byte(a), []byte(b))
-			// Match if least 25 characters, or 80% of the query text in common
-			node.IsMatch = (len(common) > 25) || (len(common)*100/len(text) >= 80)
-		}
+	if _, ok := c.GetQuery("debug"); ok || DEBUG {
+		respData.Config = &config
 	}
 
 	c.Header("Cache-Control", "public,max-age=86400")
 	c.JSON(http.StatusOK, respData)
 }
 
-func callCofactsApi(text string) (string, error) {
-	type CofactsRequestVariables struct {
-		Text string `json:"text"`
-	}
+var errEmptyQuery = errors.New("the query text is empty")
 
-	type CofactsRequest struct {
-		Query     string                  `json:"query"`
-		Variables CofactsRequestVariables `json:"variables"`
+// checkText finds the articles for a text and decides which of them match
+// it and what their replies say.
+func checkText(ctx context.Context, text string, config MatchConfig) (*CofactResponse, error) {
+	if normalizedLength(text) == 0 {
+		return nil, errEmptyQuery
 	}
-
-	cofactsQuery := CofactsRequest{
-		Query:     cofactsGqlQuery,
-		Variables: CofactsRequestVariables{Text: text},
-	}
-
-	body, err := json.Marshal(&cofactsQuery)
+	respData, err := listCandidates(ctx, text, config)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	addVerdicts(respData)
+	return respData, nil
+}
 
-	resp, err := http.Post(
-		"https://cofacts-api.g0v.tw/graphql",
-		"application/json",
-		strings.NewReader(string(body)))
-	if err != nil {
-		return "", err
+// listCandidates fetches pages of config.PageSize articles from Cofacts and
+// matches them against the query, until one of them matches, there are no
+// more, or config.MaxCandidates articles have been fetched. If a later page
+// fails, the articles fetched so far are returned.
+func listCandidates(ctx context.Context, text string, config MatchConfig) (*CofactResponse, error) {
+	var respData *CofactResponse
+	query := ArticleQuery{Text: text}
+	for {
+		fetched := 0
+		if respData != nil {
+			fetched = len(respData.Data.ListArticles.Edges)
+		}
+		query.First = config.PageSize
+		if remaining := config.MaxCandidates - fetched; remaining < query.First {
+			query.First = remaining
+		}
+
+		page, err := cofacts.ListArticles(ctx, query)
+		if err != nil {
+			if respData == nil {
+				return nil, err
+			}
+			log.Printf("Could not fetch more candidates after %d: %v", fetched, err)
+			return respData, nil
+		}
+		edges := page.Data.ListArticles.Edges
+		for i := range edges {
+			orderReplies(&edges[i].Node)
+		}
+		matchArticles(ctx, text, page, config)
+
+		if respData == nil {
+			respData = page
+		} else {
+			respData.Data.ListArticles.Edges = append(respData.Data.ListArticles.Edges, edges...)
+			respData.Data.ListArticles.PageInfo = page.Data.ListArticles.PageInfo
+		}
+
+		matched := false
+		for _, edge := range edges {
+			matched = matched || edge.Node.IsMatch
+		}
+		if matched || len(edges) < query.First || fetched+len(edges) >= config.MaxCandidates {
+			return respData, nil
+		}
+		query.After = edges[len(edges)-1].Cursor
+		if query.After == "" || query.After == page.Data.ListArticles.PageInfo.LastCursor {
+			return respData, nil
+		}
 	}
+}
 
-	defer resp.Body.Close()
-	respText, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+// matchArticles decides for each of the articles Cofacts returned whether
+// it matches the query text, using the strategies in the config. The url
+// strategy goes first regardless of its place in the list, see
+// MatchConfig.Strategies.
+func matchArticles(ctx context.Context, text string, respData *CofactResponse, config MatchConfig) {
+	// Follow roughly the same filter approach as Aunt Meiyu
+	rxStrict := xurls.Strict()
+	var request_urls []string
+	if config.uses(StrategyUrl) {
+		request_urls = shortLinks.expandShortLinks(ctx, rxStrict.FindAllString(text, -1))
+	}
+	if len(request_urls) > 0 {
+		// If there's a url in the text, it must be in the article
+		for i := range respData.Data.ListArticles.Edges {
+			node := &respData.Data.ListArticles.Edges[i].Node
+			node.Strategy = StrategyUrl
+			match := exist_same_url(node, request_urls)
+			if match == nil {
+				continue
+			}
+			node.IsMatch = true
+			node.Score = 1
+			node.Match = &MatchExplanation{
+				QueryUrl:     match.RequestUrl,
+				ArticleUrl:   match.NodeUrl,
+				UrlRules:     match.Rules,
+				QueryStart:   runeIndex(text, match.RequestUrl),
+				ArticleStart: runeIndex(node.Text, match.NodeUrl),
+			}
+			node.Match.QueryEnd = endOffset(node.Match.QueryStart, match.RequestUrl)
+			node.Match.ArticleEnd = endOffset(node.Match.ArticleStart, match.NodeUrl)
+		}
+		return
 	}
 
-	return string(respText), nil
+	// Otherwise score the text of each article against the query
+	edges := respData.Data.ListArticles.Edges
+	var tfidf []float64
+	if config.uses(StrategyTfidf) {
+		docs := make([]string, len(edges))
+		for i := range edges {
+			docs[i] = edges[i].Node.Text
+			articleCorpus.Add(edges[i].Node.Id, edges[i].Node.Text)
+		}
+		tfidf = tfidfScores(text, docs, articleCorpus)
+	}
+	var querySignature minhashSignature
+	hasShingles := false
+	if config.uses(StrategyNearDuplicate) {
+		querySignature, hasShingles = minhash(text)
+	}
+	queryLength := normalizedLength(text)
+	textLength := normalizedLength(rxStrict.ReplaceAllString(text, ""))
+	for i := range edges {
+		node := &edges[i].Node
+		var length int
+		node.Match, length = textMatch(text, node.Text)
+		node.Signals = map[string]float64{}
+		for _, strategy := range config.Strategies {
+			var score float64
+			var isMatch bool
+			switch strategy {
+			case StrategyTfidf:
+				score = tfidf[i]
+				isMatch = score >= config.TfidfThreshold
+			case StrategyNearDuplicate:
+				// A lightly edited copy of the article
+				if hasShingles {
+					score = nearDuplicateScore(querySignature, node.Text)
+				}
+				isMatch = score >= config.NearDuplicateThreshold
+			case StrategyLcs:
+				score, isMatch = lcsScore(length, queryLength, textLength, config)
+			default:
+				continue
+			}
+			node.Signals[strategy] = score
+
+			// The first strategy that matches decides, or the first one
+			// if none does.
+			if node.Strategy == "" || (isMatch && !node.IsMatch) {
+				node.Strategy = strategy
+				node.Score = score
+				node.IsMatch = isMatch
+			}
+		}
+	}
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mvdan.cc/xurls/v2"
+)
+
+func TestTextMatch(t *testing.T) {
+	tests := []struct {
+		query, article string
+		want           *MatchExplanation
+		length         int
+	}{
+		{
+			// The ligature folds into two characters at the same offset, and
+			// the emoji and whitespace are skipped.
+			query:   "ﬁ ABC 中文 😀測試",
+			article: "xx fi abc中文測試 yy",
+			want: &MatchExplanation{
+				Text:       "ﬁ ABC 中文 😀測試",
+				QueryStart: 0, QueryEnd: 12,
+				ArticleStart: 3, ArticleEnd: 13,
+			},
+			length: 9,
+		},
+		{
+			// A flag is two regional indicator code points.
+			query:   "🇹🇼 台灣加油",
+			article: "台灣加油!",
+			want: &MatchExplanation{
+				Text:       "台灣加油",
+				QueryStart: 3, QueryEnd: 7,
+				ArticleStart: 0, ArticleEnd: 4,
+			},
+			length: 4,
+		},
+		{
+			query:   "😀😀",
+			article: "😀😀",
+			want:    nil,
+		},
+		{
+			query:   "abc",
+			article: "xyz",
+			want:    nil,
+		},
+	}
+	for _, test := range tests {
+		got, length := textMatch(test.query, test.article)
+		if test.want == nil {
+			if got != nil {
+				t.Errorf("textMatch(%q, %q) = %+v, want no match", test.query, test.article, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("textMatch(%q, %q) found no match", test.query, test.article)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) || length != test.length {
+			t.Errorf("textMatch(%q, %q) = %+v, %d, want %+v, %d",
+				test.query, test.article, *got, length, *test.want, test.length)
+		}
+	}
+}
+
+func TestRuneIndex(t *testing.T) {
+	tests := []struct {
+		s, substr  string
+		start, end int
+	}{
+		{"看這個 https://example.com/a", "https://example.com/a", 4, 25},
+		{"😀 https://x.tw", "https://x.tw", 2, 14},
+		{"no url here", "https://x.tw", -1, -1},
+	}
+	for _, test := range tests {
+		start := runeIndex(test.s, test.substr)
+		if end := endOffset(start, test.substr); start != test.start || end != test.end {
+			t.Errorf("offsets of %q in %q = %d, %d, want %d, %d",
+				test.substr, test.s, start, end, test.start, test.end)
+		}
+	}
+}
+
+func TestNormalizedLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{" \t\n　", 0},
+		{"a", 1},
+		{"ＡＢＣ", 3},
+		{"中文 測試", 4},
+		{"喝水 😀 可以！！", 4},
+		// Punctuation in urls is dropped like anywhere else
+		{"https://example.com/a", 16},
+		{"請看 https://example.com/a 喔", 19},
+	}
+	for _, test := range tests {
+		if got := normalizedLength(test.text); got != test.want {
+			t.Errorf("normalizedLength(%q) = %d, want %d", test.text, got, test.want)
+		}
+	}
+}
+
+func TestLcsScore(t *testing.T) {
+	config := defaultMatchConfig()
+	tests := []struct {
+		name                            string
+		length, queryLength, textLength int
+		score                           float64
+		isMatch                         bool
+	}{
+		{"empty query", 0, 0, 0, 0, false},
+		{"whitespace-only query", 0, 0, 0, 0, false},
+		{"nothing in common", 0, 40, 40, 0, false},
+		{"long enough substring", 25, 100, 100, 0.25, true},
+		{"covers most of the query", 9, 10, 10, 0.9, true},
+		{"covers too little of the query", 7, 10, 10, 0.7, false},
+		{"very short query", 5, 5, 5, 1, false},
+		{"just too short query", 9, 9, 9, 1, false},
+		// The ratio is of the whole query, but the query must be long
+		// enough without its urls
+		{"url-only query", 16, 16, 0, 1, false},
+		{"url-only query with a long url", 30, 30, 0, 1, true},
+		{"mostly url", 12, 25, 12, 0.48, false},
+		{"substring longer than the query", 12, 10, 10, 1, true},
+	}
+	for _, test := range tests {
+		score, isMatch := lcsScore(test.length, test.queryLength, test.textLength, config)
+		if score != test.score || isMatch != test.isMatch {
+			t.Errorf("%s: lcsScore(%d, %d, %d) = %v, %v, want %v, %v", test.name,
+				test.length, test.queryLength, test.textLength, score, isMatch, test.score, test.isMatch)
+		}
+	}
+}
+
+// TestLcsMatch runs queries through the same steps as matchArticles.
+func TestLcsMatch(t *testing.T) {
+	article := "衛福部提醒：喝溫開水無法預防新型冠狀病毒，請不要再轉傳錯誤訊息。詳見 https://www.mohw.gov.tw/cp-16-48610-1.html"
+	tests := []struct {
+		query   string
+		isMatch bool
+	}{
+		{"", false},
+		{"   ", false},
+		{"喝溫開水", false},
+		{"喝溫開水無法預防新型冠狀病毒", true},
+		{"喝溫開水無法預防新型冠狀病毒，這是真的嗎？我不相信", false},
+		{"https://www.mohw.gov.tw/cp-16-48610-1.html", true},
+		{"https://mohw.gov.tw/", false},
+		{"ＷＨＯ 喝溫開水 無法預防 新型冠狀病毒 😷", true},
+		{"ＷＨＯ說 喝溫開水 無法預防 新型冠狀病毒 😷", false},
+	}
+	config := defaultMatchConfig()
+	for _, test := range tests {
+		_, length := textMatch(test.query, article)
+		queryLength := normalizedLength(test.query)
+		textLength := normalizedLength(xurls.Strict().ReplaceAllString(test.query, ""))
+		if _, isMatch := lcsScore(length, queryLength, textLength, config); isMatch != test.isMatch {
+			t.Errorf("%q: match %v, want %v (lcs %d of %d)", test.query, isMatch, test.isMatch, length, queryLength)
+		}
+	}
+}
+
+// recordingBackend records the queries it passes on to the next backend, and
+// fails the failAt-th one and those after it if failAt is set.
+type recordingBackend struct {
+	next    CofactsBackend
+	failAt  int
+	queries []ArticleQuery
+}
+
+func (backend *recordingBackend) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	backend.queries = append(backend.queries, query)
+	if backend.failAt > 0 && len(backend.queries) >= backend.failAt {
+		return nil, errors.New("cofacts is down")
+	}
+	return backend.next.ListArticles(ctx, query)
+}
+
+func TestListCandidates(t *testing.T) {
+	const matchingUrl = "https://example.com/match"
+	// articles returns n articles, of which those at the given positions
+	// link to matchingUrl.
+	articles := func(n int, matching ...int) []Node {
+		nodes := make([]Node, n)
+		for i := range nodes {
+			nodes[i] = Node{Id: strconv.Itoa(i), Text: "文章", Hyperlinks: []Hyperlink{}}
+		}
+		for _, i := range matching {
+			nodes[i].Hyperlinks = []Hyperlink{{Url: matchingUrl}}
+		}
+		return nodes
+	}
+
+	tests := []struct {
+		name          string
+		articles      []Node
+		pageSize      int
+		maxCandidates int
+		failAt        int
+
+		// The cursors asked for and the number of articles of each page
+		after    []string
+		first    []int
+		fetched  int
+		matching []string
+		err      bool
+	}{
+		{
+			name:     "match on the first page",
+			articles: articles(20, 1), pageSize: 4, maxCandidates: 20,
+			after: []string{""}, first: []int{4}, fetched: 4, matching: []string{"1"},
+		},
+		{
+			name:     "match on a later page",
+			articles: articles(20, 5, 6), pageSize: 4, maxCandidates: 20,
+			after: []string{"", "4"}, first: []int{4, 4}, fetched: 8, matching: []string{"5", "6"},
+		},
+		{
+			name:     "the last page is smaller",
+			articles: articles(6), pageSize: 4, maxCandidates: 20,
+			after: []string{"", "4"}, first: []int{4, 4}, fetched: 6,
+		},
+		{
+			name:     "the last page ends at the last cursor",
+			articles: articles(8), pageSize: 4, maxCandidates: 20,
+			after: []string{"", "4"}, first: []int{4, 4}, fetched: 8,
+		},
+		{
+			name:     "no articles",
+			articles: nil, pageSize: 4, maxCandidates: 20,
+			after: []string{""}, first: []int{4}, fetched: 0,
+		},
+		{
+			name:     "the budget ends within a page",
+			articles: articles(20), pageSize: 4, maxCandidates: 10,
+			after: []string{"", "4", "8"}, first: []int{4, 4, 2}, fetched: 10,
+		},
+		{
+			name:     "a match beyond the budget isn't fetched",
+			articles: articles(20, 12), pageSize: 4, maxCandidates: 12,
+			after: []string{"", "4", "8"}, first: []int{4, 4, 4}, fetched: 12,
+		},
+		{
+			name:     "the budget is a single page",
+			articles: articles(20), pageSize: 4, maxCandidates: 4,
+			after: []string{""}, first: []int{4}, fetched: 4,
+		},
+		{
+			name:     "a later page fails",
+			articles: articles(20, 9), pageSize: 4, maxCandidates: 20, failAt: 3,
+			after: []string{"", "4", "8"}, first: []int{4, 4, 4}, fetched: 8,
+		},
+		{
+			name:     "the first page fails",
+			articles: articles(20, 1), pageSize: 4, maxCandidates: 20, failAt: 1,
+			after: []string{""}, first: []int{4}, err: true,
+		},
+	}
+	saved := cofacts
+	defer func() { cofacts = saved }()
+	for _, test := range tests {
+		backend := &recordingBackend{next: &fakeBackend{Articles: test.articles}, failAt: test.failAt}
+		cofacts = backend
+		config := defaultMatchConfig()
+		config.Strategies = []string{StrategyUrl}
+		config.PageSize = test.pageSize
+		config.MaxCandidates = test.maxCandidates
+
+		resp, err := listCandidates(context.Background(), "看這個 "+matchingUrl, config)
+
+		var after []string
+		var first []int
+		for _, query := range backend.queries {
+			after = append(after, query.After)
+			first = append(first, query.First)
+		}
+		if !reflect.DeepEqual(after, test.after) || !reflect.DeepEqual(first, test.first) {
+			t.Errorf("%s: asked for pages after %q of %v, want after %q of %v", test.name, after, first,
+				test.after, test.first)
+		}
+		if test.err {
+			if err == nil || resp != nil {
+				t.Errorf("%s: got %v, %v, want an error", test.name, resp, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		edges := resp.Data.ListArticles.Edges
+		if len(edges) != test.fetched {
+			t.Errorf("%s: got %d articles, want %d", test.name, len(edges), test.fetched)
+		}
+		var matching []string
+		for i, edge := range edges {
+			if edge.Node.Id != strconv.Itoa(i) {
+				t.Errorf("%s: article %d is %s", test.name, i, edge.Node.Id)
+			}
+			if edge.Node.IsMatch {
+				matching = append(matching, edge.Node.Id)
+			}
+		}
+		if !reflect.DeepEqual(matching, test.matching) {
+			t.Errorf("%s: matching articles %q, want %q", test.name, matching, test.matching)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// Near-duplicate detection with MinHash. The estimated Jaccard similarity of
+// the sets of shingles of two texts stays high when a chain message is only
+// lightly edited (a name swapped, a sentence added), even if that breaks up
+// the longest common substring.
+// See http://infolab.stanford.edu/~ullman/mmds/ch3.pdf
+const (
+	shingleSize = 4
+	minhashSize = 64
+
+	// For LSH the signature is split into bands; two texts become candidates
+	// if all rows of at least one band are equal. With 16 bands of 4 rows,
+	// texts with a similarity of 0.5 are found with a probability of 0.64,
+	// and of 0.7, the default neardup_threshold, with a probability of 0.99.
+	lshBands = 16
+	lshRows  = minhashSize / lshBands
+)
+
+// Each hash function of the signature is a random permutation a*h+b (with a
+// odd, modulo 2^64) of the shingle hash, which is much cheaper than rehashing
+// the shingle.
+var minhashA, minhashB = func() ([minhashSize]uint64, [minhashSize]uint64) {
+	var a, b [minhashSize]uint64
+	// splitmix64, with a fixed seed so signatures are stable across restarts
+	x := uint64(0x5eed)
+	next := func() uint64 {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		return z ^ (z >> 31)
+	}
+	for i := range a {
+		a[i] = next() | 1
+		b[i] = next()
+	}
+	return a, b
+}()
+
+type minhashSignature [minhashSize]uint64
+
+// shingleHashes returns the hashes of the overlapping character shingles of
+// the normalized text, ignoring whitespace. Texts shorter than a shingle are
+// a single shingle.
+func shingleHashes(text string) []uint64 {
+	runes := removeWhitespace(textNormalizer.normalize(text)).runes
+	if len(runes) == 0 {
+		return nil
+	}
+	if len(runes) < shingleSize {
+		return []uint64{hashRunes(runes)}
+	}
+	hashes := make([]uint64, 0, len(runes)-shingleSize+1)
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		hashes = append(hashes, hashRunes(runes[i:i+shingleSize]))
+	}
+	return hashes
+}
+
+func hashRunes(runes []rune) uint64 {
+	h := fnv.New64a()
+	var buf [4]byte
+	for _, r := range runes {
+		binary.LittleEndian.PutUint32(buf[:], uint32(r))
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// minhash computes the signature of a text. It returns false for texts
+// without any shingles.
+func minhash(text string) (minhashSignature, bool) {
+	var sig minhashSignature
+	hashes := shingleHashes(text)
+	if len(hashes) == 0 {
+		return sig, false
+	}
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for _, h := range hashes {
+		for i := range sig {
+			if v := minhashA[i]*h + minhashB[i]; v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig, true
+}
+
+// estimateJaccard estimates the Jaccard similarity of the shingle sets from
+// two signatures, as the fraction of hash functions with the same minimum.
+func estimateJaccard(a minhashSignature, b minhashSignature) float64 {
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+	return float64(equal) / minhashSize
+}
+
+// nearDuplicateScore is the estimated Jaccard similarity of a text to the
+// query, given the signature of the query, so it is computed only once for
+// all the articles.
+func nearDuplicateScore(query minhashSignature, text string) float64 {
+	sig, ok := minhash(text)
+	if !ok {
+		return 0
+	}
+	return estimateJaccard(query, sig)
+}
+
+type lshBandKey struct {
+	band int
+	hash uint64
+}
+
+// lshIndex finds near-duplicates of a text among the indexed articles
+// without comparing against every one of them, using the banding technique
+// on the MinHash signatures.
+type lshIndex struct {
+	mu         sync.RWMutex
+	signatures map[string]minhashSignature
+	buckets    map[lshBandKey][]string
+}
+
+func newLshIndex() *lshIndex {
+	return &lshIndex{
+		signatures: make(map[string]minhashSignature),
+		buckets:    make(map[lshBandKey][]string),
+	}
+}
+
+func bandHashes(sig minhashSignature) [lshBands]uint64 {
+	var hashes [lshBands]uint64
+	for band := range hashes {
+		h := fnv.New64a()
+		var buf [8]byte
+		for _, v := range sig[band*lshRows : (band+1)*lshRows] {
+			binary.LittleEndian.PutUint64(buf[:], v)
+			h.Write(buf[:])
+		}
+		hashes[band] = h.Sum64()
+	}
+	return hashes
+}
+
+// Add indexes the text of an article. Ids should be added only once.
+func (index *lshIndex) Add(id string, text string) {
+	sig, ok := minhash(text)
+	if !ok {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.signatures[id] = sig
+	for band, h := range bandHashes(sig) {
+		key := lshBandKey{band, h}
+		index.buckets[key] = append(index.buckets[key], id)
+	}
+}
+
+// Query returns the indexed articles that share a band with text and have an
+// estimated similarity of at least threshold, most similar first.
+func (index *lshIndex) Query(text string, threshold float64) []scoredCandidate {
+	sig, ok := minhash(text)
+	if !ok {
+		return nil
+	}
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var candidates []scoredCandidate
+	for band, h := range bandHashes(sig) {
+		for _, id := range index.buckets[lshBandKey{band, h}] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if score := estimateJaccard(sig, index.signatures[id]); score >= threshold {
+				candidates = append(candidates, scoredCandidate{Id: id, Score: score})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Id < candidates[j].Id
+	})
+	return candidates
+}
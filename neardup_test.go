@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// A chain message and lightly edited copies of it, as they spread.
+const chainMessage = "緊急通知！衛生局的王小明醫師說，喝溫開水可以預防新型冠狀病毒，每十五分鐘喝一口，病毒就會被沖到胃裡被胃酸殺死。請大家轉傳給親朋好友，保護家人健康。"
+
+var chainMessageEdits = map[string]string{
+	"name swapped":     "緊急通知！衛生局的陳大文醫師說，喝溫開水可以預防新型冠狀病毒，每十五分鐘喝一口，病毒就會被沖到胃裡被胃酸殺死。請大家轉傳給親朋好友，保護家人健康。",
+	"sentence added":   "緊急通知！衛生局的王小明醫師說，喝溫開水可以預防新型冠狀病毒，每十五分鐘喝一口，病毒就會被沖到胃裡被胃酸殺死。這是真的，我同學的爸爸就是醫生。請大家轉傳給親朋好友，保護家人健康。",
+	"sentence removed": "衛生局的王小明醫師說，喝溫開水可以預防新型冠狀病毒，每十五分鐘喝一口，病毒就會被沖到胃裡被胃酸殺死。",
+}
+
+// Two unrelated messages that end in the same boilerplate sentence.
+var boilerplatePair = [2]string{
+	"明天開始停水三天，請大家務必轉傳給十個群組，轉發的人會平安健康，功德無量，阿彌陀佛。",
+	"吃香蕉配牛奶會中毒，請大家務必轉傳給十個群組，轉發的人會平安健康，功德無量，阿彌陀佛。",
+}
+
+// exactJaccard is the Jaccard similarity of the shingle sets that the
+// signatures estimate.
+func exactJaccard(a string, b string) float64 {
+	setA, setB := make(map[uint64]bool), make(map[uint64]bool)
+	for _, h := range shingleHashes(a) {
+		setA[h] = true
+	}
+	for _, h := range shingleHashes(b) {
+		setB[h] = true
+	}
+	union := len(setA)
+	intersection := 0
+	for h := range setB {
+		if setA[h] {
+			intersection++
+		} else {
+			union++
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+func TestMinhash(t *testing.T) {
+	for _, text := range []string{"", " \t\n", "😀！"} {
+		if _, ok := minhash(text); ok {
+			t.Errorf("minhash(%q) has a signature", text)
+		}
+	}
+	if _, ok := minhash("短"); !ok {
+		t.Errorf("a text shorter than a shingle has no signature")
+	}
+
+	// The text is normalized and whitespace ignored first
+	a, _ := minhash("ＡＢＣ 喝溫開水")
+	b, _ := minhash("abc喝溫開水！")
+	if a != b {
+		t.Errorf("the signatures of the same normalized text differ")
+	}
+	if estimateJaccard(a, b) != 1 {
+		t.Errorf("estimateJaccard of equal signatures = %v", estimateJaccard(a, b))
+	}
+}
+
+func TestEstimateJaccard(t *testing.T) {
+	texts := []string{chainMessage, boilerplatePair[0], boilerplatePair[1], "今天天氣很好，我們去公園散步吧"}
+	for _, edit := range chainMessageEdits {
+		texts = append(texts, edit)
+	}
+	for i, a := range texts {
+		for _, b := range texts[i+1:] {
+			sigA, _ := minhash(a)
+			sigB, _ := minhash(b)
+			// Allow three standard errors of the estimate with 64 hashes
+			got, want := estimateJaccard(sigA, sigB), exactJaccard(a, b)
+			if math.Abs(got-want) > 3*math.Sqrt(want*(1-want)/minhashSize)+0.01 {
+				t.Errorf("estimateJaccard(%.10q, %.10q) = %v, exact %v", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestNearDuplicateThreshold(t *testing.T) {
+	threshold := defaultMatchConfig().NearDuplicateThreshold
+	query, _ := minhash(chainMessage)
+	for name, edit := range chainMessageEdits {
+		if score := nearDuplicateScore(query, edit); score < threshold {
+			t.Errorf("%s: score %v, below %v", name, score, threshold)
+		}
+	}
+
+	query, _ = minhash(boilerplatePair[0])
+	if score := nearDuplicateScore(query, boilerplatePair[1]); score >= threshold {
+		t.Errorf("messages sharing boilerplate: score %v, at least %v", score, threshold)
+	}
+	if score := nearDuplicateScore(query, ""); score != 0 {
+		t.Errorf("empty text: score %v", score)
+	}
+}
+
+func TestLshIndexQuery(t *testing.T) {
+	index := newLshIndex()
+	index.Add("chain", chainMessage)
+	index.Add("boilerplate", boilerplatePair[1])
+	index.Add("empty", "  ")
+	index.Add("weather", "今天天氣很好，我們去公園散步吧")
+
+	threshold := defaultMatchConfig().NearDuplicateThreshold
+	for name, edit := range chainMessageEdits {
+		got := index.Query(edit, threshold)
+		if len(got) != 1 || got[0].Id != "chain" || got[0].Score < threshold {
+			t.Errorf("%s: got %+v", name, got)
+		}
+	}
+	if got := index.Query(boilerplatePair[0], threshold); len(got) != 0 {
+		t.Errorf("messages sharing boilerplate: got %+v", got)
+	}
+	if got := index.Query(chainMessage, 0); len(got) == 0 || got[0].Id != "chain" || got[0].Score != 1 {
+		t.Errorf("the indexed text itself: got %+v", got)
+	}
+	if got := index.Query("", 0); got != nil {
+		t.Errorf("empty query: got %+v", got)
+	}
+}
+
+// TestLshRecall checks the probabilities of finding a near-duplicate given
+// in the comment on lshBands, on random texts with random characters
+// replaced.
+func TestLshRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	alphabet := []rune("的一是不了人我在有他這中大來上國個到說們為子和你地出道也時年得就那要下以生會自著去之過家學對可她裡後小麼心多天而能好都然沒日於起還發成事只作當想看文無開手十用主行方又如前所本見經頭面公同三已老從動兩長")
+	random := func(n int) []rune {
+		text := make([]rune, n)
+		for i := range text {
+			text[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return text
+	}
+
+	index := newLshIndex()
+	type bucket struct{ found, total int }
+	buckets := map[float64]*bucket{0.5: {}, 0.7: {}}
+	for i := 0; i < 3000; i++ {
+		article := random(80)
+		id := string(article)
+		index.Add(id, id)
+
+		edited := append([]rune(nil), article...)
+		for n := rng.Intn(8); n >= 0; n-- {
+			edited[rng.Intn(len(edited))] = alphabet[rng.Intn(len(alphabet))]
+		}
+		sigA, _ := minhash(id)
+		sigB, _ := minhash(string(edited))
+		similarity := estimateJaccard(sigA, sigB)
+		var b *bucket
+		switch {
+		case similarity >= 0.45 && similarity < 0.55:
+			b = buckets[0.5]
+		case similarity >= 0.65 && similarity < 0.75:
+			b = buckets[0.7]
+		default:
+			continue
+		}
+		b.total++
+		for _, c := range index.Query(string(edited), 0) {
+			if c.Id == id {
+				b.found++
+			}
+		}
+	}
+
+	for similarity, want := range map[float64]struct{ min, max float64 }{0.5: {0.54, 0.74}, 0.7: {0.97, 1}} {
+		b := buckets[similarity]
+		if b.total < 100 {
+			t.Fatalf("only %d pairs with a similarity around %v", b.total, similarity)
+		}
+		// The buckets are 0.1 wide, so allow some leeway around the
+		// probabilities of exactly 0.5 and 0.7
+		if recall := float64(b.found) / float64(b.total); recall < want.min || recall > want.max {
+			t.Errorf("similarity around %v: found %d of %d, want a recall between %v and %v",
+				similarity, b.found, b.total, want.min, want.max)
+		}
+	}
+}
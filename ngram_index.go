@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Sizes of the character n-grams in the index. Bigrams find short CJK words,
+// trigrams keep common bigrams from dominating the overlap.
+var ngramSizes = []int{2, 3}
+
+// ngrams returns the distinct character n-grams of the normalized text,
+// ignoring whitespace.
+func ngrams(text string) map[string]bool {
+	runes := removeWhitespace(textNormalizer.normalize(text)).runes
+	grams := make(map[string]bool)
+	for _, n := range ngramSizes {
+		for i := 0; i+n <= len(runes); i++ {
+			grams[string(runes[i:i+n])] = true
+		}
+	}
+	if len(runes) > 0 && len(runes) < ngramSizes[0] {
+		grams[string(runes)] = true
+	}
+	return grams
+}
+
+type scoredCandidate struct {
+	Id    string
+	Score float64
+}
+
+// ngramIndex is an inverted index from character n-grams to the articles
+// containing them, used to find candidates for the matching logic among
+// locally stored articles. Articles can be added at any time, and the index
+// can be saved to disk so it doesn't have to be rebuilt on every start. The
+// n-grams are taken from the normalized text, so a snapshot records the
+// normalization steps and isn't loaded once they change.
+type ngramIndex struct {
+	mu sync.RWMutex
+
+	// Exported for gob. Docs are identified by their position in Ids; an
+	// empty id marks a doc that was replaced by a later Add.
+	Ids      []string
+	Sizes    []int
+	Postings map[string][]int
+	Position map[string]int
+}
+
+func newNgramIndex() *ngramIndex {
+	return &ngramIndex{
+		Postings: make(map[string][]int),
+		Position: make(map[string]int),
+	}
+}
+
+// Add indexes the text of an article. Adding an id that is already in the
+// index replaces it.
+func (index *ngramIndex) Add(id string, text string) {
+	grams := ngrams(text)
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if old, ok := index.Position[id]; ok {
+		index.Ids[old] = ""
+	}
+	doc := len(index.Ids)
+	index.Ids = append(index.Ids, id)
+	index.Sizes = append(index.Sizes, len(grams))
+	index.Position[id] = doc
+	for gram := range grams {
+		index.Postings[gram] = append(index.Postings[gram], doc)
+	}
+}
+
+func (index *ngramIndex) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return len(index.Position)
+}
+
+// TopK returns the k articles that share the most n-grams with text, best
+// first. The score is the cosine similarity of the n-gram sets, between 0
+// and 1, so long articles don't win just by containing more n-grams.
+func (index *ngramIndex) TopK(text string, k int) []scoredCandidate {
+	grams := ngrams(text)
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	shared := make(map[int]int)
+	for gram := range grams {
+		for _, doc := range index.Postings[gram] {
+			shared[doc]++
+		}
+	}
+
+	candidates := make([]scoredCandidate, 0, len(shared))
+	for doc, count := range shared {
+		if index.Ids[doc] == "" {
+			continue
+		}
+		score := float64(count) / math.Sqrt(float64(len(grams)*index.Sizes[doc]))
+		candidates = append(candidates, scoredCandidate{Id: index.Ids[doc], Score: score})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Id < candidates[j].Id
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+// ngramSnapshotHeader precedes the index in a snapshot and records what it
+// was built from, so a snapshot that no longer fits the store or the
+// normalization steps is rebuilt instead of used.
+type ngramSnapshotHeader struct {
+	NormalizeSteps string
+	Articles       int
+}
+
+// Save writes a snapshot of the index to path.
+func (index *ngramIndex) Save(path string) error {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	header := ngramSnapshotHeader{
+		NormalizeSteps: textNormalizerSteps,
+		Articles:       len(index.Position),
+	}
+	enc := gob.NewEncoder(f)
+	if err := enc.Encode(header); err != nil {
+		f.Close()
+		return err
+	}
+	if err := enc.Encode(index); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadNgramIndex loads a snapshot of the index for a store with the given
+// number of articles. It fails if the snapshot was built from a different
+// number of articles or with other normalization steps than the current ones.
+func loadNgramIndex(path string, articles int) (*ngramIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(f)
+	var header ngramSnapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if header.NormalizeSteps != textNormalizerSteps {
+		return nil, fmt.Errorf("%s was built with the normalization steps %q, not %q",
+			path, header.NormalizeSteps, textNormalizerSteps)
+	}
+	if header.Articles != articles {
+		return nil, fmt.Errorf("%s was built from %d articles, not %d", path, header.Articles, articles)
+	}
+	index := newNgramIndex()
+	if err := dec.Decode(index); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return index, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNgrams(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{" ！ ", nil},
+		{"水", []string{"水"}},
+		{"喝水", []string{"喝水"}},
+		{"Ｈi 水！", []string{"hi", "i水", "hi水"}},
+		{"喝溫開水", []string{"喝溫", "溫開", "開水", "喝溫開", "溫開水"}},
+	}
+	for _, test := range tests {
+		want := make(map[string]bool)
+		for _, gram := range test.want {
+			want[gram] = true
+		}
+		if got := ngrams(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("ngrams(%q) = %v, want %v", test.text, got, want)
+		}
+	}
+}
+
+func TestNgramIndex(t *testing.T) {
+	index := newNgramIndex()
+	index.Add("water", "喝溫開水可以預防病毒")
+	index.Add("lemon", "喝檸檬水可以治療癌症")
+	index.Add("weather", "今天天氣很好")
+	if index.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", index.Len())
+	}
+
+	got := index.TopK("喝溫開水可以預防", 10)
+	if len(got) != 2 || got[0].Id != "water" || got[1].Id != "lemon" {
+		t.Fatalf("TopK = %+v, want water then lemon", got)
+	}
+	if got[0].Score <= got[1].Score || got[0].Score > 1 {
+		t.Errorf("scores %v and %v", got[0].Score, got[1].Score)
+	}
+	if got := index.TopK("喝溫開水可以預防病毒", 1); len(got) != 1 || got[0].Id != "water" || got[0].Score < 1-1e-9 {
+		t.Errorf("TopK of the indexed text with k = 1: %+v", got)
+	}
+	if got := index.TopK("", 10); len(got) != 0 {
+		t.Errorf("TopK of an empty text: %+v", got)
+	}
+
+	// Adding an id again replaces its text
+	index.Add("water", "今天天氣很好，出去走走")
+	if index.Len() != 3 {
+		t.Errorf("Len() after replacing = %d, want 3", index.Len())
+	}
+	for _, c := range index.TopK("喝溫開水可以預防", 10) {
+		if c.Id == "water" {
+			t.Errorf("the replaced text is still found: %+v", c)
+		}
+	}
+	got = index.TopK("今天天氣很好", 10)
+	if len(got) != 2 || got[0].Id != "weather" || got[1].Id != "water" {
+		t.Errorf("TopK after replacing = %+v, want weather then water", got)
+	}
+}
+
+func TestNgramIndexSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ngram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.gob.index")
+
+	index := newNgramIndex()
+	index.Add("water", "喝溫開水可以預防病毒")
+	index.Add("lemon", "喝檸檬水可以治療癌症")
+	index.Add("water", "喝溫開水可以預防新型冠狀病毒")
+	if err := index.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadNgramIndex(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Ids, index.Ids) || !reflect.DeepEqual(loaded.Postings, index.Postings) ||
+		!reflect.DeepEqual(loaded.Position, index.Position) || !reflect.DeepEqual(loaded.Sizes, index.Sizes) {
+		t.Errorf("the loaded index differs")
+	}
+	query := "喝溫開水可以預防"
+	if got, want := loaded.TopK(query, 10), index.TopK(query, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("TopK of the loaded index = %+v, want %+v", got, want)
+	}
+	// The loaded index can still grow
+	loaded.Add("weather", "今天天氣很好")
+	if got := loaded.TopK("天氣很好", 1); len(got) != 1 || got[0].Id != "weather" {
+		t.Errorf("TopK after adding to the loaded index = %+v", got)
+	}
+
+	// A snapshot of a different number of articles isn't loaded
+	if _, err := loadNgramIndex(path, 3); err == nil || !strings.Contains(err.Error(), "2 articles") {
+		t.Errorf("loading the snapshot for 3 articles: %v", err)
+	}
+
+	// Nor one built with other normalization steps
+	saved := textNormalizerSteps
+	defer setTextNormalizer(saved)
+	if err := setTextNormalizer("nfkc, lowercase"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadNgramIndex(path, 2); err == nil || !strings.Contains(err.Error(), "normalization steps") {
+		t.Errorf("loading the snapshot after changing the normalization: %v", err)
+	}
+	if err := index.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadNgramIndex(path, 2); err != nil {
+		t.Errorf("loading the snapshot saved with the same normalization: %v", err)
+	}
+
+	// Or something that isn't a snapshot at all
+	if err := ioutil.WriteFile(path, []byte("not a snapshot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadNgramIndex(path, 2); err == nil {
+		t.Errorf("loaded a file that isn't a snapshot")
+	}
+}
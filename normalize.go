@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// normalizedText is text after some normalization steps, remembering for
+// every rune which rune of the original text it came from. This lets us
+// report match offsets in the text the user actually sent.
+type normalizedText struct {
+	runes   []rune
+	offsets []int
+}
+
+func newNormalizedText(s string) normalizedText {
+	runes := []rune(s)
+	offsets := make([]int, len(runes))
+	for i := range offsets {
+		offsets[i] = i
+	}
+	return normalizedText{runes: runes, offsets: offsets}
+}
+
+func (t normalizedText) String() string {
+	return string(t.runes)
+}
+
+// mapRunes replaces every rune with the result of f, which may be empty to
+// drop the rune. Replacements keep the offset of the rune they replace.
+func (t normalizedText) mapRunes(f func(r rune) []rune) normalizedText {
+	result := normalizedText{
+		runes:   make([]rune, 0, len(t.runes)),
+		offsets: make([]int, 0, len(t.offsets)),
+	}
+	for i, r := range t.runes {
+		for _, replacement := range f(r) {
+			result.runes = append(result.runes, replacement)
+			result.offsets = append(result.offsets, t.offsets[i])
+		}
+	}
+	return result
+}
+
+func (t normalizedText) filter(drop func(r rune) bool) normalizedText {
+	return t.mapRunes(func(r rune) []rune {
+		if drop(r) {
+			return nil
+		}
+		return []rune{r}
+	})
+}
+
+type normalizeStep func(t normalizedText) normalizedText
+
+// normalizeNFKC applies Unicode compatibility composition, which folds
+// compatibility ideographs, ligatures, circled numbers, full-width Latin and
+// the like into their plain equivalents.
+func normalizeNFKC(t normalizedText) normalizedText {
+	s := t.String()
+
+	// Byte offset in s to index in t.runes
+	runeAt := make([]int, len(s)+1)
+	i := 0
+	for pos := range s {
+		runeAt[pos] = i
+		i++
+	}
+	runeAt[len(s)] = len(t.runes)
+
+	result := normalizedText{
+		runes:   make([]rune, 0, len(t.runes)),
+		offsets: make([]int, 0, len(t.offsets)),
+	}
+	// Segments are the smallest units that normalize independently, usually
+	// a single character with any combining marks.
+	var it norm.Iter
+	it.InitString(norm.NFKC, s)
+	for !it.Done() {
+		offset := t.offsets[runeAt[it.Pos()]]
+		for _, r := range string(it.Next()) {
+			result.runes = append(result.runes, r)
+			result.offsets = append(result.offsets, offset)
+		}
+	}
+	return result
+}
+
+// foldWidth maps full-width ASCII to half-width and half-width katakana to
+// full-width, which is what NFKC misses for text typed on phone keyboards.
+func foldWidth(t normalizedText) normalizedText {
+	return t.mapRunes(func(r rune) []rune {
+		if folded := width.LookupRune(r).Folded(); folded != 0 {
+			return []rune{folded}
+		}
+		return []rune{r}
+	})
+}
+
+func toLower(t normalizedText) normalizedText {
+	return t.mapRunes(func(r rune) []rune {
+		return []rune{unicode.ToLower(r)}
+	})
+}
+
+func removePunctuation(t normalizedText) normalizedText {
+	return t.filter(unicode.IsPunct)
+}
+
+func isZeroWidth(r rune) bool {
+	switch r {
+	case '\u200b', // zero width space
+		'\u200c', // zero width non-joiner
+		'\u200d', // zero width joiner
+		'\u2060', // word joiner
+		'\ufeff', // zero width no-break space (BOM)
+		'\u00ad': // soft hyphen
+		return true
+	}
+	return false
+}
+
+func removeZeroWidth(t normalizedText) normalizedText {
+	return t.filter(isZeroWidth)
+}
+
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1f000 && r <= 0x1faff: // emoticons, pictographs, flags, skin tones
+		return true
+	case r >= 0x2600 && r <= 0x27bf: // miscellaneous symbols and dingbats
+		return true
+	case r >= 0x2b00 && r <= 0x2bff: // arrows, stars, etc.
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f: // variation selectors
+		return true
+	case r >= 0xe0020 && r <= 0xe007f: // tags, used in subdivision flags
+		return true
+	}
+	return false
+}
+
+func removeEmoji(t normalizedText) normalizedText {
+	return t.filter(isEmoji)
+}
+
+func removeWhitespace(t normalizedText) normalizedText {
+	return t.filter(unicode.IsSpace)
+}
+
+// A small table of the most common Simplified characters that differ from
+// their Traditional form, as pairs of simplified and traditional characters.
+// Characters that map to several traditional ones depending on the word,
+// such as 后 (后 or 後) and 发 (發 or 髮), are left out, as mapping them one
+// way would break the other. A complete table can be loaded with
+// loadSimplifiedToTraditional.
+const builtinSimplifiedToTraditional = "" +
+	"这這个個们們来來说說时時会會对對为為国國过過还還没沒见見现現实實点點问問" +
+	"题題关關经經长長开開门門东東车車马馬鸟鳥鱼魚龙龍电電话話语語请請让讓认認" +
+	"识識读讀写寫书書学學习習医醫药藥疗療传傳转轉给給亲親专專网網络絡钱錢银銀" +
+	"买買卖賣动動万萬与與业業严嚴丧喪临臨举舉义義乐樂乡鄉产產亿億从從众眾优優" +
+	"伤傷体體俭儉债債儿兒军軍农農决決况況净淨减減击擊刘劉则則刚剛创創删刪剧劇" +
+	"办辦务務劳勞势勢区區华華单單卫衛压壓厅廳县縣参參双雙变變号號吗嗎听聽员員" +
+	"响響围圍图圖园園场場坏壞块塊坚堅声聲处處备備头頭夺奪奋奮妇婦妈媽宁寧宝寶" +
+	"审審宪憲宫宮宽寬导導寿壽将將尔爾尘塵层層岁歲岛島师師带帶帮幫广廣庆慶应應" +
+	"庙廟废廢异異弹彈张張归歸录錄彻徹径徑总總悬懸惊驚惯慣愿願戏戲战戰执執扩擴" +
+	"扫掃扬揚护護报報担擔拥擁择擇挂掛挥揮损損换換据據摄攝携攜敌敵数數断斷无無" +
+	"旧舊显顯晋晉机機杀殺杂雜权權条條极極构構标標树樹样樣检檢欢歡欧歐残殘毁毀" +
+	"气氣汉漢沟溝泪淚泽澤洁潔浅淺测測济濟浓濃涨漲温溫湾灣满滿灭滅灯燈灵靈灾災" +
+	"炉爐炼煉烟煙热熱爱愛爷爺牵牽状狀独獨狮獅猎獵献獻环環画畫畅暢盐鹽监監盖蓋" +
+	"盘盤矿礦码碼确確离離积積称稱稳穩穷窮竞競笔筆简簡类類粮糧紧緊红紅级級纪紀" +
+	"约約纯純纸紙线線练練组組细細终終绍紹结結绝絕统統继繼绩績续續维維综綜缘緣" +
+	"编編罗羅罚罰职職联聯肃肅肠腸肤膚胁脅脑腦脸臉节節营營虽雖补補装裝观觀规規" +
+	"视視览覽觉覺计計订訂讨討训訓议議讯訊记記讲講许許论論设設访訪证證评評诉訴" +
+	"诊診词詞试試诗詩诚誠详詳误誤诸諸课課谁誰调調谈談谢謝谣謠谎謊谋謀谓謂贝貝" +
+	"负負财財责責败敗货貨质質购購贵貴费費贴貼资資赛賽赵趙赶趕趋趨跃躍践踐踪蹤" +
+	"躯軀轨軌轮輪软軟轻輕载載输輸辅輔辆輛边邊达達迁遷运運进進远遠违違连連迟遲" +
+	"选選递遞遗遺邮郵邻鄰酱醬释釋鉴鑑针針钢鋼铁鐵链鏈销銷锁鎖错錯锻鍛键鍵镇鎮" +
+	"闭閉闲閒间間闻聞阅閱队隊阳陽阴陰阵陣阶階际際陆陸险險随隨隐隱难難雾霧顶頂" +
+	"项項顺順预預领領频頻颗顆颜顏风風飞飛饭飯饮飲饼餅馆館驱驅验驗骗騙鸡雞麦麥" +
+	"黄黃齐齊齿齒龟龜"
+
+var simplifiedToTraditional = parseCharacterPairs(builtinSimplifiedToTraditional)
+
+func parseCharacterPairs(pairs string) map[rune]rune {
+	runes := []rune(pairs)
+	table := make(map[rune]rune, len(runes)/2)
+	for i := 0; i+1 < len(runes); i += 2 {
+		table[runes[i]] = runes[i+1]
+	}
+	return table
+}
+
+// loadSimplifiedToTraditional adds the mappings from an OpenCC style
+// character table (STCharacters.txt) to the built-in table. Each line has a
+// simplified character, a tab, and one or more space separated traditional
+// characters, of which we use the first.
+func loadSimplifiedToTraditional(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		from, fromSize := utf8.DecodeRuneInString(fields[0])
+		to, toSize := utf8.DecodeRuneInString(fields[1])
+		if fromSize != len(fields[0]) || toSize != len(fields[1]) {
+			// Phrase mappings can't be applied character by character
+			continue
+		}
+		if from == utf8.RuneError || to == utf8.RuneError {
+			return fmt.Errorf("%s:%d: invalid character", path, line)
+		}
+		simplifiedToTraditional[from] = to
+	}
+	return scanner.Err()
+}
+
+func toTraditional(t normalizedText) normalizedText {
+	return t.mapRunes(func(r rune) []rune {
+		if traditional, ok := simplifiedToTraditional[r]; ok {
+			return []rune{traditional}
+		}
+		return []rune{r}
+	})
+}
+
+// All available normalization steps, by the name used in the NORMALIZE
+// environment variable.
+var normalizeSteps = map[string]normalizeStep{
+	"nfkc":        normalizeNFKC,
+	"width":       foldWidth,
+	"lowercase":   toLower,
+	"zerowidth":   removeZeroWidth,
+	"emoji":       removeEmoji,
+	"punctuation": removePunctuation,
+	"traditional": toTraditional,
+}
+
+// Steps that run before matching unless NORMALIZE says otherwise. The order
+// matters: NFKC first so the later steps see the folded characters.
+const defaultNormalizeSteps = "nfkc,width,lowercase,zerowidth,emoji,punctuation"
+
+var textNormalizer normalizer
+
+// textNormalizerSteps names the steps of textNormalizer, so that snapshots of
+// data derived from normalized text can tell whether they are out of date.
+var textNormalizerSteps string
+
+func init() {
+	if err := setTextNormalizer(defaultNormalizeSteps); err != nil {
+		panic(err)
+	}
+}
+
+// setTextNormalizer replaces textNormalizer with a pipeline of the steps in a
+// comma separated list.
+func setTextNormalizer(steps string) error {
+	n, err := newNormalizer(steps)
+	if err != nil {
+		return err
+	}
+	textNormalizer = n
+	textNormalizerSteps = strings.Join(stepNames(steps), ",")
+	return nil
+}
+
+// stepNames splits a comma separated list of step names.
+func stepNames(steps string) []string {
+	var names []string
+	for _, name := range strings.Split(steps, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// normalizer is a pipeline of normalization steps that is run over both the
+// query and the article texts before they are compared.
+type normalizer []normalizeStep
+
+// newNormalizer creates a pipeline from a comma separated list of step names.
+func newNormalizer(steps string) (normalizer, error) {
+	var n normalizer
+	for _, name := range stepNames(steps) {
+		step, ok := normalizeSteps[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown normalization step %q", name)
+		}
+		n = append(n, step)
+	}
+	return n, nil
+}
+
+func (n normalizer) normalize(s string) normalizedText {
+	t := newNormalizedText(s)
+	for _, step := range n {
+		t = step(t)
+	}
+	return t
+}
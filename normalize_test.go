@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeSteps(t *testing.T) {
+	tests := []struct {
+		step    string
+		input   string
+		want    string
+		offsets []int
+	}{
+		{"nfkc", "ﬁ①Ａ", "fi1A", []int{0, 0, 1, 2}},
+		{"nfkc", "e\u0301x", "éx", []int{0, 2}},
+		{"width", "ｈｉ ｶﾀｶﾅ", "hi カタカナ", []int{0, 1, 2, 3, 4, 5, 6}},
+		{"lowercase", "ABC Ä", "abc ä", []int{0, 1, 2, 3, 4}},
+		{"zerowidth", "a\u200bb\u00adc\ufeff", "abc", []int{0, 2, 4}},
+		{"emoji", "a😀b🇹🇼c❤️", "abc", []int{0, 2, 5}},
+		{"punctuation", "你好，世界！", "你好世界", []int{0, 1, 3, 4}},
+		{"traditional", "这个消息", "這個消息", []int{0, 1, 2, 3}},
+		// Characters with several traditional forms are left alone
+		{"traditional", "皇后", "皇后", []int{0, 1}},
+		{"traditional", "头发", "頭发", []int{0, 1}},
+	}
+	for _, test := range tests {
+		got := normalizeSteps[test.step](newNormalizedText(test.input))
+		if got.String() != test.want || !reflect.DeepEqual(got.offsets, test.offsets) {
+			t.Errorf("%s(%q) = %q %v, want %q %v",
+				test.step, test.input, got.String(), got.offsets, test.want, test.offsets)
+		}
+	}
+}
+
+func TestRemoveWhitespace(t *testing.T) {
+	got := removeWhitespace(newNormalizedText("a b\tc\n"))
+	if got.String() != "abc" || !reflect.DeepEqual(got.offsets, []int{0, 2, 4}) {
+		t.Errorf("removeWhitespace = %q %v", got.String(), got.offsets)
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	tests := []struct {
+		steps   string
+		input   string
+		want    string
+		offsets []int
+	}{
+		{defaultNormalizeSteps, "Ａ ﬁ，😀B", "a fib", []int{0, 1, 2, 2, 5}},
+		{"lowercase", "Ａ ﬁ，😀B", "ａ ﬁ，😀b", []int{0, 1, 2, 3, 4, 5}},
+		{"", "Ａ ﬁ", "Ａ ﬁ", []int{0, 1, 2}},
+		{"nfkc, traditional", "ＡI这", "AI這", []int{0, 1, 2}},
+	}
+	for _, test := range tests {
+		n, err := newNormalizer(test.steps)
+		if err != nil {
+			t.Fatalf("newNormalizer(%q): %v", test.steps, err)
+		}
+		got := n.normalize(test.input)
+		if got.String() != test.want || !reflect.DeepEqual(got.offsets, test.offsets) {
+			t.Errorf("newNormalizer(%q).normalize(%q) = %q %v, want %q %v",
+				test.steps, test.input, got.String(), got.offsets, test.want, test.offsets)
+		}
+	}
+
+	if _, err := newNormalizer("nfkc,bogus"); err == nil {
+		t.Error("newNormalizer accepted an unknown step")
+	}
+}
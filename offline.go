@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"mvdan.cc/xurls/v2"
+)
+
+const defaultOfflineLimit = 10
+
+// articleStore holds the articles from the Cofacts open data dump
+// (https://github.com/cofacts/opendata), with their replies, in the same
+// shape as the articles returned by the api.
+type articleStore struct {
+	Articles []Node
+}
+
+func loadArticleStore(path string) (*articleStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var store articleStore
+	if err := gob.NewDecoder(f).Decode(&store); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// Gob doesn't distinguish empty slices from nil, but the api returns [].
+	for i := range store.Articles {
+		if store.Articles[i].Hyperlinks == nil {
+			store.Articles[i].Hyperlinks = []Hyperlink{}
+		}
+		if store.Articles[i].ArticleReplies == nil {
+			store.Articles[i].ArticleReplies = []ArticleReplies{}
+		}
+	}
+	return &store, nil
+}
+
+func (store *articleStore) save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(store); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readCsv calls f for every row of a csv file, with the row as a map from
+// column name to value, so we don't depend on the order of the columns.
+func readCsv(path string, f func(row map[string]string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		if err := f(row); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+// atoiOrZero parses the counts in the dump, which are empty for older rows.
+func atoiOrZero(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// importOpenData builds an article store from the articles.csv, replies.csv
+// and article_replies.csv files of the Cofacts open data dump in dir.
+// Replies that were deleted by their author are left out.
+func importOpenData(dir string) (*articleStore, error) {
+	replies := make(map[string]ArticleReply)
+	err := readCsv(filepath.Join(dir, "replies.csv"), func(row map[string]string) error {
+		replies[row["id"]] = ArticleReply{
+			Id:        row["id"],
+			Text:      row["text"],
+			Type:      row["type"],
+			Reference: row["reference"],
+			CreatedAt: row["createdAt"],
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	articleReplies := make(map[string][]ArticleReplies)
+	err = readCsv(filepath.Join(dir, "article_replies.csv"), func(row map[string]string) error {
+		if status := row["status"]; status != "" && status != "NORMAL" {
+			return nil
+		}
+		reply, ok := replies[row["replyId"]]
+		if !ok {
+			return nil
+		}
+		articleId := row["articleId"]
+		articleReplies[articleId] = append(articleReplies[articleId], ArticleReplies{
+			Reply:                 reply,
+			Status:                "NORMAL",
+			CreatedAt:             row["createdAt"],
+			PositiveFeedbackCount: atoiOrZero(row["positiveFeedbackCount"]),
+			NegativeFeedbackCount: atoiOrZero(row["negativeFeedbackCount"]),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	store := &articleStore{}
+	rxStrict := xurls.Strict()
+	err = readCsv(filepath.Join(dir, "articles.csv"), func(row map[string]string) error {
+		node := Node{
+			Id:                row["id"],
+			Text:              row["text"],
+			CreatedAt:         row["createdAt"],
+			ReplyCount:        len(articleReplies[row["id"]]),
+			ReplyRequestCount: atoiOrZero(row["replyRequestCount"]),
+			ArticleReplies:    articleReplies[row["id"]],
+		}
+		// The dump doesn't include the hyperlinks Cofacts extracted, but
+		// they come from the urls in the text.
+		for _, u := range rxStrict.FindAllString(node.Text, -1) {
+			node.Hyperlinks = append(node.Hyperlinks, Hyperlink{Url: u})
+		}
+		store.Articles = append(store.Articles, node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// offlineBackend is a CofactsBackend that answers from a local article store
+// instead of the Cofacts api, so we keep working during outages and can test
+// without network. Candidates are near-duplicates of the query found through
+// MinHash, followed by the best matches from an n-gram index over the article
+// texts.
+type offlineBackend struct {
+	store    *articleStore
+	index    *ngramIndex
+	nearDups *lshIndex
+	articles map[string]int // id to position in store.Articles
+	Limit    int
+
+	// How similar an article must be to the query to be a near-duplicate
+	// candidate, usually the neardup_threshold of the config.
+	NearDuplicateThreshold float64
+}
+
+// newOfflineBackend serves the articles in store, using index to find them.
+// If index is nil, a new one is built from the store.
+func newOfflineBackend(store *articleStore, index *ngramIndex) *offlineBackend {
+	backend := &offlineBackend{
+		store:    store,
+		index:    index,
+		nearDups: newLshIndex(),
+		articles: make(map[string]int, len(store.Articles)),
+		Limit:    defaultOfflineLimit,
+
+		NearDuplicateThreshold: defaultMatchConfig().NearDuplicateThreshold,
+	}
+	for i, article := range store.Articles {
+		backend.articles[article.Id] = i
+		backend.nearDups.Add(article.Id, article.Text)
+	}
+	if backend.index == nil {
+		backend.index = buildNgramIndex(store)
+	}
+	return backend
+}
+
+func buildNgramIndex(store *articleStore) *ngramIndex {
+	index := newNgramIndex()
+	for _, article := range store.Articles {
+		index.Add(article.Id, article.Text)
+	}
+	return index
+}
+
+// ListArticles returns a page of the first Limit candidates. The cursors are
+// the positions of the articles among the candidates.
+func (backend *offlineBackend) ListArticles(ctx context.Context, query ArticleQuery) (*CofactResponse, error) {
+	start := 0
+	if query.After != "" {
+		var err error
+		start, err = strconv.Atoi(query.After)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid cursor %q", query.After)
+		}
+	}
+	first := query.First
+	if first == 0 {
+		first = backend.Limit
+	}
+
+	// The indexes only rank roughly; the matching logic does the real
+	// scoring afterwards. Neither lookup can be interrupted, so check the
+	// context between them.
+	text := query.Text
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	candidates := backend.nearDups.Query(text, backend.NearDuplicateThreshold)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	candidates = append(candidates, backend.index.TopK(text, backend.Limit)...)
+
+	var articles []int
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if len(articles) == backend.Limit {
+			break
+		}
+		i, ok := backend.articles[c.Id]
+		if !ok || seen[c.Id] {
+			// Already added, or the index is out of date with the store
+			continue
+		}
+		seen[c.Id] = true
+		articles = append(articles, i)
+	}
+
+	resp := &CofactResponse{}
+	resp.Data.ListArticles.Edges = make([]Edge, 0, first)
+	for pos := start; pos < len(articles) && pos < start+first; pos++ {
+		resp.Data.ListArticles.Edges = append(resp.Data.ListArticles.Edges, Edge{
+			Node:   backend.store.Articles[articles[pos]],
+			Cursor: strconv.Itoa(pos + 1),
+		})
+	}
+	if len(articles) > 0 {
+		resp.Data.ListArticles.PageInfo.LastCursor = strconv.Itoa(len(articles))
+	}
+	return resp, nil
+}
+
+// indexPath is where the snapshot of the n-gram index for a store is kept.
+func indexPath(storePath string) string {
+	return storePath + ".index"
+}
+
+// runImportCommand implements the import subcommand, which converts the
+// open data csv files into an article store for the offline backend, and
+// saves a snapshot of its n-gram index next to it.
+func runImportCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s import <open data directory> <store file>", os.Args[0])
+	}
+	store, err := importOpenData(args[0])
+	if err != nil {
+		return err
+	}
+	if err := store.save(args[1]); err != nil {
+		return err
+	}
+	if err := buildNgramIndex(store).Save(indexPath(args[1])); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d articles\n", len(store.Articles))
+	return nil
+}
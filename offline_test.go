@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportOpenData(t *testing.T) {
+	store, err := importOpenData(filepath.Join("testdata", "opendata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Articles) != 3 {
+		t.Fatalf("imported %d articles, want 3", len(store.Articles))
+	}
+
+	a1 := store.Articles[0]
+	if a1.Id != "a1" || a1.CreatedAt != "2020-02-01T08:00:00.000Z" || a1.ReplyRequestCount != 12 {
+		t.Errorf("article a1 = %+v", a1)
+	}
+	// The deleted reply is left out
+	want := []ArticleReplies{{
+		Reply: ArticleReply{
+			Id:        "r1",
+			Text:      "喝水無法預防病毒感染。",
+			Type:      ReplyRumor,
+			Reference: "https://www.mohw.gov.tw/",
+			CreatedAt: "2020-02-02T00:00:00.000Z",
+		},
+		Status:                "NORMAL",
+		CreatedAt:             "2020-02-02T00:00:00.000Z",
+		PositiveFeedbackCount: 10,
+		NegativeFeedbackCount: 1,
+	}}
+	if a1.ReplyCount != 1 || !reflect.DeepEqual(a1.ArticleReplies, want) {
+		t.Errorf("replies of a1 = %d, %+v", a1.ReplyCount, a1.ArticleReplies)
+	}
+
+	a2 := store.Articles[1]
+	if !reflect.DeepEqual(a2.Hyperlinks, []Hyperlink{{Url: "https://reurl.cc/abc"}}) {
+		t.Errorf("hyperlinks of a2 = %+v", a2.Hyperlinks)
+	}
+	if len(a2.ArticleReplies) != 1 || a2.ArticleReplies[0].PositiveFeedbackCount != 0 {
+		t.Errorf("replies of a2 = %+v", a2.ArticleReplies)
+	}
+
+	a3 := store.Articles[2]
+	if a3.ReplyRequestCount != 0 || a3.ReplyCount != 0 || a3.ArticleReplies != nil {
+		t.Errorf("article a3 = %+v", a3)
+	}
+
+	// The store survives saving and loading, with empty lists instead of nil
+	dir, err := ioutil.TempDir("", "offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.gob")
+	if err := store.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadArticleStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Articles[1], a2) || loaded.Articles[0].Hyperlinks == nil ||
+		loaded.Articles[2].ArticleReplies == nil {
+		t.Errorf("loaded store differs: %+v", loaded.Articles)
+	}
+}
+
+func TestOfflineBackend(t *testing.T) {
+	store, err := importOpenData(filepath.Join("testdata", "opendata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := newOfflineBackend(store, nil)
+	query := ArticleQuery{Text: "緊急通知！喝溫開水可以預防新型冠狀病毒，請大家轉傳給親朋好友，保護家人。"}
+
+	resp, err := backend.ListArticles(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := resp.Data.ListArticles.Edges
+	if len(edges) == 0 || edges[0].Node.Id != "a1" {
+		t.Fatalf("the near-duplicate isn't the first candidate: %+v", edges)
+	}
+
+	// Pages follow the cursors
+	query.First = 1
+	page, err := backend.ListArticles(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data.ListArticles.Edges) != 1 || page.Data.ListArticles.Edges[0].Cursor != "1" {
+		t.Fatalf("first page = %+v", page.Data.ListArticles.Edges)
+	}
+	if len(edges) > 1 {
+		query.After = "1"
+		page, err = backend.ListArticles(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Data.ListArticles.Edges[0].Node.Id != edges[1].Node.Id {
+			t.Errorf("second page starts with %s, want %s", page.Data.ListArticles.Edges[0].Node.Id, edges[1].Node.Id)
+		}
+	}
+	query.After = "x"
+	if _, err := backend.ListArticles(context.Background(), query); err == nil {
+		t.Errorf("an invalid cursor was accepted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := backend.ListArticles(ctx, ArticleQuery{Text: query.Text}); err != context.Canceled {
+		t.Errorf("with a cancelled context: got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenAPI 3 description of the api, served at /openapi.json. The schemas
+// are derived from the Go types the handlers respond with, so they can't
+// drift from what is actually sent.
+
+type openapiSchemas struct {
+	components map[string]interface{}
+}
+
+// schema returns the schema of t, adding named struct types to the
+// components and referring to them.
+func (s *openapiSchemas) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Slice:
+		// encoding/json writes nil slices and maps as null
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem()), "nullable": true}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem()), "nullable": true}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := s.components[t.Name()]; ok {
+			return ref
+		}
+		// Added before the fields, so recursive types refer to themselves.
+		object := map[string]interface{}{"type": "object"}
+		s.components[t.Name()] = object
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			name := strings.Split(tag, ",")[0]
+			if tag == "-" || field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = s.schema(field.Type)
+			if !strings.Contains(tag, ",omitempty") {
+				required = append(required, name)
+			}
+		}
+		object["properties"] = properties
+		if len(required) > 0 {
+			object["required"] = required
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func (s *openapiSchemas) of(v interface{}) map[string]interface{} {
+	return s.schema(reflect.TypeOf(v))
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+func textContent() map[string]interface{} {
+	return map[string]interface{}{
+		"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+	}
+}
+
+// buildOpenapi describes all the routes set up in newRouter.
+func buildOpenapi() map[string]interface{} {
+	s := &openapiSchemas{components: map[string]interface{}{}}
+
+	// The settings of MatchConfig, and debug, can be given as query
+	// parameters on every route that matches texts.
+	var matchParameters []interface{}
+	for _, key := range matchConfigKeys {
+		matchParameters = append(matchParameters, map[string]interface{}{
+			"name":        key,
+			"in":          "query",
+			"description": "Overrides the " + key + " setting of the match configuration",
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	matchParameters = append(matchParameters, map[string]interface{}{
+		"name":            "debug",
+		"in":              "query",
+		"description":     "Include the effective match configuration in the response",
+		"schema":          map[string]interface{}{"type": "string"},
+		"allowEmptyValue": true,
+	})
+	withHeader := func(parameters []interface{}, header map[string]interface{}) []interface{} {
+		return append([]interface{}{header}, parameters...)
+	}
+
+	errorContent := jsonContent(s.of(ErrorResponse{}))
+	errorResponses := map[string]interface{}{
+		"400": map[string]interface{}{"description": "Invalid request", "content": errorContent},
+		"500": map[string]interface{}{"description": "Internal error", "content": errorContent},
+		"502": map[string]interface{}{"description": "Cofacts is unavailable or answered with an error", "content": errorContent},
+		"504": map[string]interface{}{"description": "Cofacts didn't answer in time", "content": errorContent},
+	}
+	responses := func(description string, content map[string]interface{}) map[string]interface{} {
+		r := map[string]interface{}{
+			"200": map[string]interface{}{"description": description, "content": content},
+		}
+		for code, response := range errorResponses {
+			r[code] = response
+		}
+		return r
+	}
+	withNotFound := func(r map[string]interface{}) map[string]interface{} {
+		r["404"] = map[string]interface{}{"description": "No such stream, or it was already read or expired",
+			"content": errorContent}
+		return r
+	}
+	cofactsResponses := responses("The articles Cofacts found, with the match results added",
+		jsonContent(s.of(CofactResponse{})))
+	batchItems := map[string]interface{}{
+		"required": true,
+		"content":  jsonContent(s.of([]BatchItem{})),
+	}
+	stream := map[string]interface{}{
+		"text/event-stream": map[string]interface{}{
+			"schema": map[string]interface{}{
+				"type": "string",
+				"description": "A result event with a BatchResult for every item as soon as it is " +
+					"ready, and finally a summary event with a StreamSummary",
+			},
+		},
+	}
+	s.of(StreamSummary{})
+
+	paths := map[string]interface{}{
+		"/cofacts": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Check a text given in the text header",
+				"parameters": withHeader(matchParameters, map[string]interface{}{
+					"name":        "text",
+					"in":          "header",
+					"required":    true,
+					"description": "The URL encoded text",
+					"schema":      map[string]interface{}{"type": "string"},
+				}),
+				"responses":  cofactsResponses,
+				"deprecated": true,
+			},
+			"post": map[string]interface{}{
+				"summary":     "Check the text in the request body",
+				"parameters":  matchParameters,
+				"requestBody": map[string]interface{}{"required": true, "content": textContent()},
+				"responses":   cofactsResponses,
+				"deprecated":  true,
+			},
+		},
+		"/cofacts/batch": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary":     "Check many texts at once",
+				"parameters":  matchParameters,
+				"requestBody": batchItems,
+				"responses":   responses("The result of every item", jsonContent(s.of(BatchResponse{}))),
+			},
+		},
+		"/cofacts/stream": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary": "Post many texts to check, to stream the results with GET",
+				"description": "EventSource can't send a body, so the items are posted first and " +
+					"the stream is read with the id of the response",
+				"parameters":  matchParameters,
+				"requestBody": batchItems,
+				"responses":   responses("The id to read the stream with", jsonContent(s.of(StreamTicket{}))),
+			},
+			"get": map[string]interface{}{
+				"summary": "Stream the results of posted texts",
+				"description": "Can be read with EventSource. A stream can only be read once, so " +
+					"close the EventSource on the summary event",
+				"parameters": []interface{}{map[string]interface{}{
+					"name":        "id",
+					"in":          "query",
+					"required":    true,
+					"description": "The id that POST /cofacts/stream answered with",
+					"schema":      map[string]interface{}{"type": "string"},
+				}},
+				"responses": withNotFound(responses("The results as Server-Sent Events", stream)),
+			},
+		},
+		"/v2/check": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary":    "Check a text",
+				"parameters": matchParameters,
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content":  jsonContent(s.of(CheckRequest{})),
+				},
+				"responses": responses("The articles that match the text", jsonContent(s.of(CheckResponse{}))),
+			},
+		},
+		"/stats": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Cache statistics",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The counters of the response cache, if it is enabled",
+						"content": jsonContent(map[string]interface{}{
+							"type":       "object",
+							"properties": map[string]interface{}{"cache": s.of(CacheStats{})},
+						}),
+					},
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Cofacts chrome extension server",
+			"description": "Finds the Cofacts articles that match a text and what their replies say.",
+			"version":     "2",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": s.components},
+	}
+}
+
+var openapi struct {
+	once sync.Once
+	doc  map[string]interface{}
+}
+
+func handleOpenapi(c *gin.Context) {
+	openapi.once.Do(func() {
+		openapi.doc = buildOpenapi()
+	})
+	c.JSON(http.StatusOK, openapi.doc)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// validateSchema checks a decoded JSON value against the subset of OpenAPI
+// schemas buildOpenapi writes. Unlike OpenAPI, properties that aren't in the
+// schema are an error, so a field added to a response type without showing
+// up in the description is caught.
+func validateSchema(components map[string]interface{}, schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		component, ok := components[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, ref)
+		}
+		return validateSchema(components, component, value, path)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: null but not nullable", path)
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range allOf {
+			if err := validateSchema(components, s.(map[string]interface{}), value, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %T, want an object", path, value)
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required %s", path, name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, v := range object {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				property = additional
+			}
+			if property == nil {
+				return fmt.Errorf("%s: %s isn't in the schema", path, name)
+			}
+			if err := validateSchema(components, property, v, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %T, want an array", path, value)
+		}
+		items := schema["items"].(map[string]interface{})
+		for i, v := range array {
+			if err := validateSchema(components, items, v, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: %T, want a string", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %T, want a boolean", path, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: %T, want a number", path, value)
+		}
+	case "integer":
+		if f, ok := value.(float64); !ok || f != math.Trunc(f) {
+			return fmt.Errorf("%s: %v, want an integer", path, value)
+		}
+	default:
+		return fmt.Errorf("%s: schema without a type: %v", path, schema)
+	}
+	return nil
+}
+
+func TestOpenapiMatchesResponses(t *testing.T) {
+	backend := &fakeBackend{
+		Articles: testArticles,
+		Errors:   map[string]error{"上游故障": &UpstreamStatusError{StatusCode: 503}},
+	}
+	router, restore := newTestRouter(backend)
+	defer restore()
+
+	// The description as it is served
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc struct {
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("/openapi.json: %v", err)
+	}
+
+	rumor := testArticles[0].Text
+	tests := []struct {
+		method, path string
+		header, body string
+		status       int
+	}{
+		{"GET", "/cofacts", url.QueryEscape(rumor), "", http.StatusOK},
+		{"GET", "/cofacts?debug", url.QueryEscape("完全無關的文字內容"), "", http.StatusOK},
+		{"POST", "/cofacts", "", rumor, http.StatusOK},
+		{"POST", "/cofacts", "", "上游故障", http.StatusBadGateway},
+		{"POST", "/cofacts", "", " ", http.StatusBadRequest},
+		{"POST", "/cofacts?page_size=0", "", rumor, http.StatusBadRequest},
+		{"POST", "/cofacts/batch?debug", "", `[{"id": "1", "text": "` + rumor + `"}, {"id": "2", "text": ""},
+			{"id": "3", "text": "上游故障"}]`, http.StatusOK},
+		{"POST", "/cofacts/batch", "", `{}`, http.StatusBadRequest},
+		{"POST", "/cofacts/stream?debug", "", `[{"id": "1", "text": "` + rumor + `"}]`, http.StatusOK},
+		{"POST", "/cofacts/stream", "", `{}`, http.StatusBadRequest},
+		{"GET", "/cofacts/stream", "", "", http.StatusBadRequest},
+		{"GET", "/cofacts/stream?id=unknown", "", "", http.StatusNotFound},
+		{"POST", "/v2/check", "", `{"id": "x", "text": "` + rumor + `"}`, http.StatusOK},
+		{"POST", "/v2/check?debug", "", `{"text": "完全無關的文字內容"}`, http.StatusOK},
+		{"POST", "/v2/check", "", `{"text": "上游故障"}`, http.StatusBadGateway},
+		{"POST", "/v2/check", "", `not json`, http.StatusBadRequest},
+		{"GET", "/stats", "", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.header != "" {
+			req.Header.Set("text", test.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		name := test.method + " " + test.path
+		if w.Code != test.status {
+			t.Errorf("%s: got %d, want %d: %s", name, w.Code, test.status, w.Body)
+			continue
+		}
+
+		path := strings.Split(test.path, "?")[0]
+		operation, ok := doc.Paths[path][strings.ToLower(test.method)]
+		if !ok {
+			t.Errorf("%s isn't described", name)
+			continue
+		}
+		responses := operation["responses"].(map[string]interface{})
+		response, ok := responses[strconv.Itoa(w.Code)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: status %d isn't described", name, w.Code)
+			continue
+		}
+		content := response["content"].(map[string]interface{})["application/json"].(map[string]interface{})
+
+		var value interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		schema := content["schema"].(map[string]interface{})
+		if err := validateSchema(doc.Components.Schemas, schema, value, "response"); err != nil {
+			t.Errorf("%s: %v\n%s", name, err, w.Body)
+		}
+	}
+}
+
+// TestOpenapiRoutes checks that every route of the router but /openapi.json
+// itself is described, and nothing else.
+func TestOpenapiRoutes(t *testing.T) {
+	router, restore := newTestRouter(&fakeBackend{})
+	defer restore()
+
+	var routes, described []string
+	for _, route := range router.Routes() {
+		if strings.HasPrefix(route.Path, "/static") || route.Method == http.MethodHead ||
+			route.Path == "/openapi.json" {
+			continue
+		}
+		routes = append(routes, route.Method+" "+route.Path)
+	}
+	for path, operations := range buildOpenapi()["paths"].(map[string]interface{}) {
+		for method := range operations.(map[string]interface{}) {
+			described = append(described, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(described)
+	if strings.Join(routes, "\n") != strings.Join(described, "\n") {
+		t.Errorf("routes:\n%s\n\ndescribed:\n%s", strings.Join(routes, "\n"), strings.Join(described, "\n"))
+	}
+}
@@ -1,2 +1,3 @@
 go mod tidy
 go mod vendor
+go generate
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Url shorteners commonly used to share hoaxes on LINE and Facebook.
+var defaultShorteners = []string{
+	"bit.ly",
+	"reurl.cc",
+	"lin.ee",
+	"pse.is",
+	"goo.gl",
+	"tinyurl.com",
+	"ppt.cc",
+	"t.co",
+	"is.gd",
+	"ow.ly",
+}
+
+const (
+	defaultMaxHops        = 5
+	defaultExpandTimeout  = 5 * time.Second
+	maxExpandCacheEntries = 10000
+
+	// How long a link that couldn't be expanded is remembered, so a broken
+	// or slow shortener doesn't delay every request that contains it.
+	expandFailureTtl = time.Minute
+)
+
+// linkExpander resolves short links to the url they redirect to, so they can
+// be compared with the hyperlinks stored in Cofacts. Only urls on one of the
+// Shorteners domains are followed, and only as long as the redirects stay on
+// shortener domains, so we never fetch the actual (possibly malicious) page.
+type linkExpander struct {
+	Client     *http.Client
+	Shorteners map[string]bool
+	MaxHops    int
+
+	mu    sync.Mutex
+	cache map[string]expandResult
+}
+
+// expandResult is a cached expansion. Failures expire, expansions don't.
+type expandResult struct {
+	url     string
+	err     error
+	expires time.Time
+}
+
+func newLinkExpander(shorteners []string) *linkExpander {
+	e := &linkExpander{
+		Client: &http.Client{
+			Timeout: defaultExpandTimeout,
+			// We follow redirects ourselves to check every hop.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Shorteners: make(map[string]bool),
+		MaxHops:    defaultMaxHops,
+		cache:      make(map[string]expandResult),
+	}
+	for _, s := range shorteners {
+		e.Shorteners[s] = true
+	}
+	return e
+}
+
+var shortLinks = newLinkExpander(defaultShorteners)
+
+func (e *linkExpander) isShortLink(u *url.URL) bool {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return e.Shorteners[host]
+}
+
+// expand returns the url that raw redirects to. Urls that aren't short links
+// are returned unchanged, without any request being made.
+func (e *linkExpander) expand(ctx context.Context, raw string) (string, error) {
+	current := raw
+	if !strings.Contains(current, "://") {
+		current = "http://" + current
+	}
+	u, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	if !e.isShortLink(u) {
+		return raw, nil
+	}
+
+	e.mu.Lock()
+	cached, ok := e.cache[raw]
+	e.mu.Unlock()
+	if ok && (cached.expires.IsZero() || time.Now().Before(cached.expires)) {
+		return cached.url, cached.err
+	}
+
+	expanded, err := e.follow(ctx, raw, u)
+	if err != nil && ctx.Err() != nil {
+		// Our caller gave up, that says nothing about the link
+		return "", err
+	}
+	result := expandResult{url: expanded, err: err}
+	if err != nil {
+		result.expires = time.Now().Add(expandFailureTtl)
+	}
+	e.mu.Lock()
+	if len(e.cache) >= maxExpandCacheEntries {
+		// Good enough to keep memory bounded; popular links are back soon.
+		e.cache = make(map[string]expandResult)
+	}
+	e.cache[raw] = result
+	e.mu.Unlock()
+	return expanded, err
+}
+
+// follow resolves redirects from u for as long as they stay on shorteners.
+func (e *linkExpander) follow(ctx context.Context, raw string, u *url.URL) (string, error) {
+	for hop := 0; e.isShortLink(u); hop++ {
+		if hop >= e.MaxHops {
+			return "", fmt.Errorf("%s: more than %d redirects", raw, e.MaxHops)
+		}
+		next, err := e.resolve(ctx, u)
+		if err != nil {
+			return "", err
+		}
+		u = next
+	}
+	return u.String(), nil
+}
+
+// resolve does a single request and returns the location it redirects to.
+func (e *linkExpander) resolve(ctx context.Context, u *url.URL) (*url.URL, error) {
+	resp, err := e.do(ctx, http.MethodHead, u)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		resp, err = e.do(ctx, http.MethodGet, u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s: expected a redirect, got %s", u, resp.Status)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	return location, nil
+}
+
+func (e *linkExpander) do(ctx context.Context, method string, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return e.Client.Do(req.WithContext(ctx))
+}
+
+// expandShortLinks adds the expanded form of any short links to urls. The
+// short links themselves are kept, as Cofacts sometimes stores those too.
+func (e *linkExpander) expandShortLinks(ctx context.Context, urls []string) []string {
+	result := append([]string(nil), urls...)
+	for _, u := range urls {
+		expanded, err := e.expand(ctx, u)
+		if err != nil {
+			log.Printf("Could not expand short link: %v", err)
+			continue
+		}
+		if expanded != u {
+			result = append(result, expanded)
+		}
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestExpander returns an expander that treats the test server as a
+// shortener, and a counter of the requests the server got.
+func newTestExpander(t *testing.T, handler http.HandlerFunc) (*linkExpander, *httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler(w, r)
+	}))
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newLinkExpander([]string{u.Hostname()})
+	return e, server, &requests
+}
+
+func TestExpandShortLinks(t *testing.T) {
+	e, server, requests := newTestExpander(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "https://www.mohw.gov.tw/cp-16-48610-1.html", http.StatusFound)
+		case "/get-only":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.Redirect(w, r, "https://example.com/target", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	urls := []string{"https://example.com/x", server.URL + "/a", server.URL + "/get-only"}
+	got := e.expandShortLinks(ctx, urls)
+	want := append(urls, "https://www.mohw.gov.tw/cp-16-48610-1.html", "https://example.com/target")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandShortLinks(%q) = %q, want %q", urls, got, want)
+	}
+	n := atomic.LoadInt32(requests)
+
+	// Expansions are cached
+	e.expandShortLinks(ctx, urls)
+	if atomic.LoadInt32(requests) != n {
+		t.Errorf("cached links were requested again")
+	}
+
+	// Links that don't expand are kept as they are
+	urls = []string{server.URL + "/missing", server.URL + "/loop"}
+	if got := e.expandShortLinks(ctx, urls); !reflect.DeepEqual(got, urls) {
+		t.Errorf("expandShortLinks(%q) = %q", urls, got)
+	}
+	if _, err := e.expand(ctx, server.URL+"/loop"); err == nil {
+		t.Errorf("a redirect loop expanded")
+	}
+}
+
+func TestExpandCachesFailures(t *testing.T) {
+	e, server, requests := newTestExpander(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer server.Close()
+	ctx := context.Background()
+	link := server.URL + "/broken"
+
+	for i := 0; i < 3; i++ {
+		if _, err := e.expand(ctx, link); err == nil {
+			t.Fatal("a failing shortener expanded")
+		}
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("the failing link was requested %d times, want once", n)
+	}
+
+	// Once the failure expires the link is tried again
+	e.mu.Lock()
+	result := e.cache[link]
+	result.expires = time.Now().Add(-time.Second)
+	e.cache[link] = result
+	e.mu.Unlock()
+	e.expand(ctx, link)
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("the expired failure was requested %d times, want twice", n)
+	}
+}
+
+func TestExpandContext(t *testing.T) {
+	release := make(chan struct{})
+	e, server, _ := newTestExpander(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		http.Redirect(w, r, "https://example.com/target", http.StatusFound)
+	})
+	defer server.Close()
+	defer close(release)
+	link := server.URL + "/slow"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := e.expand(ctx, link); err == nil {
+		t.Fatal("expand didn't stop when the context expired")
+	}
+	if elapsed := time.Since(start); elapsed > defaultExpandTimeout/2 {
+		t.Errorf("expand took %v after the context expired", elapsed)
+	}
+
+	// A cancelled request isn't a broken link, so it isn't cached
+	e.mu.Lock()
+	_, cached := e.cache[link]
+	e.mu.Unlock()
+	if cached {
+		t.Errorf("the cancelled expansion was cached")
+	}
+}
+
+func TestExpandIgnoresOtherLinks(t *testing.T) {
+	e, server, requests := newTestExpander(t, func(w http.ResponseWriter, r *http.Request) {})
+	defer server.Close()
+	for _, link := range []string{"https://example.com/a", "www.cdc.gov.tw", "mohw.gov.tw/x"} {
+		got, err := e.expand(context.Background(), link)
+		if err != nil || got != link {
+			t.Errorf("expand(%q) = %q, %v", link, got, err)
+		}
+	}
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Errorf("%d requests for links that aren't short", n)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// tokenize splits text into terms for the similarity engine. Latin words and
+// numbers become lowercased word tokens. CJK text has no word boundaries, so
+// each run of CJK characters is split into overlapping character bigrams (a
+// single character on its own becomes a unigram). Everything else, such as
+// punctuation and whitespace, only acts as a separator.
+func tokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		if len(cjk) == 1 {
+			tokens = append(tokens, string(cjk))
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			tokens = append(tokens, string(cjk[i:i+2]))
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+func termFrequencies(tokens []string) map[string]float64 {
+	tf := make(map[string]float64, len(tokens))
+	for _, t := range tokens {
+		tf[t]++
+	}
+	return tf
+}
+
+// The most articles the corpus counts, to bound its memory.
+const maxCorpusArticles = 200000
+
+// documentFrequencies counts in how many articles each term appears, over
+// all the articles the server has seen. The few candidates Cofacts returns
+// for one query can't tell boilerplate, such as the plea to forward shared
+// by countless unrelated chain letters, from what a message is about; the
+// whole corpus can. Each article is counted once, by id.
+type documentFrequencies struct {
+	mu       sync.RWMutex
+	articles map[string]bool
+	df       map[string]int
+}
+
+func newDocumentFrequencies() *documentFrequencies {
+	return &documentFrequencies{
+		articles: make(map[string]bool),
+		df:       make(map[string]int),
+	}
+}
+
+// The corpus used for matching, filled from the offline store in main and
+// with the articles Cofacts returns as they come in.
+var articleCorpus = newDocumentFrequencies()
+
+// Add counts the terms of an article, unless it was counted before or the
+// corpus is full.
+func (corpus *documentFrequencies) Add(id string, text string) {
+	corpus.mu.RLock()
+	known := corpus.articles[id] || len(corpus.articles) >= maxCorpusArticles
+	corpus.mu.RUnlock()
+	if known {
+		return
+	}
+	tf := termFrequencies(tokenize(textNormalizer.normalize(text).String()))
+
+	corpus.mu.Lock()
+	defer corpus.mu.Unlock()
+	if corpus.articles[id] || len(corpus.articles) >= maxCorpusArticles {
+		return
+	}
+	corpus.articles[id] = true
+	for t := range tf {
+		corpus.df[t]++
+	}
+}
+
+// Len returns the number of articles counted.
+func (corpus *documentFrequencies) Len() int {
+	corpus.mu.RLock()
+	defer corpus.mu.RUnlock()
+	return len(corpus.articles)
+}
+
+// tfidfScores returns the TF-IDF cosine similarity between the normalized
+// query and each of the documents, in the same order, from 0 to 1. Document
+// frequencies come from the corpus, which should already include the
+// documents, so terms that appear in many articles (such as boilerplate
+// shared by chain letters) weigh less than distinctive ones. Without a
+// corpus they are computed over the documents themselves.
+func tfidfScores(query string, docs []string, corpus *documentFrequencies) []float64 {
+	queryTf := termFrequencies(tokenize(textNormalizer.normalize(query).String()))
+	docTfs := make([]map[string]float64, len(docs))
+	for i, doc := range docs {
+		docTfs[i] = termFrequencies(tokenize(textNormalizer.normalize(doc).String()))
+	}
+
+	var n float64
+	df := make(map[string]int)
+	if corpus != nil {
+		corpus.mu.RLock()
+		n = float64(len(corpus.articles))
+		for _, tf := range append(docTfs, queryTf) {
+			for t := range tf {
+				df[t] = corpus.df[t]
+			}
+		}
+		corpus.mu.RUnlock()
+	} else {
+		n = float64(len(docs))
+		for _, tf := range docTfs {
+			for t := range tf {
+				df[t]++
+			}
+		}
+	}
+	idf := func(t string) float64 {
+		// Smoothed idf, so a term present in all documents still counts a little.
+		return 1 + math.Log((n+1)/(float64(df[t])+1))
+	}
+
+	scores := make([]float64, len(docs))
+	for i, docTf := range docTfs {
+		var dot, queryNorm, docNorm float64
+		for t, f := range queryTf {
+			w := f * idf(t)
+			queryNorm += w * w
+			dot += w * docTf[t] * idf(t)
+		}
+		for t, f := range docTf {
+			w := f * idf(t)
+			docNorm += w * w
+		}
+		if queryNorm == 0 || docNorm == 0 {
+			continue
+		}
+		// Rounding can take identical texts just above 1
+		scores[i] = math.Min(1, dot/(math.Sqrt(queryNorm)*math.Sqrt(docNorm)))
+	}
+	return scores
+}
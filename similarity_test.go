@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mvdan.cc/xurls/v2"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{" ，。！ ", nil},
+		{"Hello World 2020", []string{"hello", "world", "2020"}},
+		{"喝溫開水", []string{"喝溫", "溫開", "開水"}},
+		{"水", []string{"水"}},
+		{"快！轉傳", []string{"快", "轉傳"}},
+		{"COVID19疫苗 mRNA", []string{"covid19", "疫苗", "mrna"}},
+		{"ひらがなカタカナ한국", []string{"ひら", "らが", "がな", "なカ", "カタ", "タカ", "カナ", "ナ한", "한국"}},
+	}
+	for _, test := range tests {
+		if got := tokenize(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+// newTestCorpus returns a corpus in which the boilerplate of
+// boilerplatePair is common, like in the Cofacts database.
+func newTestCorpus() *documentFrequencies {
+	claims := []string{"今晚十二點起手機會被監聽", "喝檸檬水可以治療癌症", "郵局要收回所有舊鈔", "颱風天不能洗頭",
+		"微波爐加熱的水會致癌", "吃蒜頭可以殺死流感病毒", "新版身分證有晶片會追蹤", "捷運站有人用針頭攻擊路人",
+		"鹽水漱口可以預防肺炎", "明年起機車全面禁行市區"}
+	others := []string{"今天天氣很好，我們去公園散步吧", "衛福部提醒：網傳領取口罩補助是詐騙，請勿點擊。",
+		"總統府今日宣布新的防疫措施，民眾應配合", "台北市將於下月舉辦美食展，歡迎民眾參加",
+		"研究指出每天運動三十分鐘有助健康", "氣象局預報週末有鋒面通過，北部轉涼"}
+	corpus := newDocumentFrequencies()
+	for i, claim := range claims {
+		corpus.Add("chain"+strconv.Itoa(i), claim+"，請大家務必轉傳給十個群組，轉發的人會平安健康，功德無量，阿彌陀佛。")
+	}
+	for i, text := range others {
+		corpus.Add("other"+strconv.Itoa(i), text)
+	}
+	return corpus
+}
+
+func TestDocumentFrequencies(t *testing.T) {
+	corpus := newDocumentFrequencies()
+	corpus.Add("a", "喝溫開水 喝溫開水")
+	corpus.Add("a", "喝溫開水")
+	corpus.Add("b", "溫開水")
+	if corpus.Len() != 2 {
+		t.Errorf("counted %d articles, want 2", corpus.Len())
+	}
+	want := map[string]int{"喝溫": 1, "溫開": 2, "開水": 2}
+	if !reflect.DeepEqual(corpus.df, want) {
+		t.Errorf("df = %v, want %v", corpus.df, want)
+	}
+}
+
+func TestTfidfScores(t *testing.T) {
+	paraphrase := "醫師說每十五分鐘喝一口溫開水，新型冠狀病毒就會被沖進胃裡，讓胃酸殺死，可以預防感染。"
+	tests := []struct {
+		name       string
+		query, doc string
+		min, max   float64
+	}{
+		// Rounding may leave identical texts just below 1, but never above
+		{"identical", chainMessage, chainMessage, 1 - 1e-9, 1},
+		{"identical, above 1 unless clamped", "今天天氣很好，我們去公園散步吧", "今天天氣很好，我們去公園散步吧", 1 - 1e-9, 1},
+		{"only normalization differs", "ＣＯＶＩＤ 疫苗！", "covid疫苗", 1 - 1e-9, 1},
+		{"nothing in common", chainMessage, "今天天氣很好，我們去公園散步吧", 0, 0},
+		{"empty query", "", chainMessage, 0, 0},
+		{"empty article", chainMessage, "", 0, 0},
+		{"sentence removed", chainMessage, chainMessageEdits["sentence removed"], 0.7, 1},
+		{"paraphrase", chainMessage, paraphrase, 0.4, 0.7},
+		{"shared boilerplate", boilerplatePair[0], boilerplatePair[1], 0, 0.4},
+	}
+	for _, test := range tests {
+		corpus := newTestCorpus()
+		corpus.Add("doc", test.doc)
+		score := tfidfScores(test.query, []string{test.doc}, corpus)[0]
+		if score < test.min || score > test.max {
+			t.Errorf("%s: score %v, want between %v and %v", test.name, score, test.min, test.max)
+		}
+	}
+
+	// Without a corpus the documents are the only ones counted
+	scores := tfidfScores(boilerplatePair[1], []string{boilerplatePair[1], boilerplatePair[0], "今天天氣很好"}, nil)
+	if scores[0] < 1-1e-9 || scores[0] > 1 || scores[1] <= 0 || scores[1] >= 1 || scores[2] != 0 {
+		t.Errorf("scores without a corpus = %v", scores)
+	}
+}
+
+// TestDefaultStrategies runs queries through matchArticles with the default
+// configuration.
+func TestDefaultStrategies(t *testing.T) {
+	saved := articleCorpus
+	defer func() { articleCorpus = saved }()
+
+	tests := []struct {
+		name           string
+		query, article string
+		isMatch        bool
+		strategy       string
+	}{
+		{"identical", chainMessage, chainMessage, true, StrategyTfidf},
+		{"name swapped", chainMessage, chainMessageEdits["name swapped"], true, StrategyTfidf},
+		{"sentence added", chainMessageEdits["sentence added"], chainMessage, true, StrategyTfidf},
+		{"paraphrase", chainMessage, "醫師說每十五分鐘喝一口溫開水，新型冠狀病毒就會被沖進胃裡，讓胃酸殺死，可以預防感染。",
+			true, StrategyTfidf},
+		{"shared boilerplate", boilerplatePair[0], boilerplatePair[1], false, StrategyTfidf},
+		{"unrelated", chainMessage, "今天天氣很好，我們去公園散步吧", false, StrategyTfidf},
+		{"same url", "看這個 https://example.com/news/1", "完全不同的文字 https://example.com/news/1",
+			true, StrategyUrl},
+	}
+	for _, test := range tests {
+		articleCorpus = newTestCorpus()
+		article := Node{Id: "article", Text: test.article}
+		for _, u := range xurls.Strict().FindAllString(test.article, -1) {
+			article.Hyperlinks = append(article.Hyperlinks, Hyperlink{Url: u})
+		}
+		resp := &CofactResponse{}
+		resp.Data.ListArticles.Edges = []Edge{{Node: article}}
+		matchArticles(context.Background(), test.query, resp, defaultMatchConfig())
+		node := resp.Data.ListArticles.Edges[0].Node
+		if node.IsMatch != test.isMatch || node.Strategy != test.strategy {
+			t.Errorf("%s: match %v by %q, want %v by %q, signals %v", test.name,
+				node.IsMatch, node.Strategy, test.isMatch, test.strategy, node.Signals)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// How often an idle stream sends a comment, so proxies such as the Heroku
+// router, which closes connections after 55 seconds without data, keep it
+// open while a slow lookup is running. A variable so tests can shorten it.
+var streamHeartbeat = 15 * time.Second
+
+// StreamSummary is the last event of a stream.
+type StreamSummary struct {
+	Items     int   `json:"items"`
+	Matched   int   `json:"matched"`
+	Errors    int   `json:"errors"`
+	ElapsedMs int64 `json:"elapsedms"`
+}
+
+const (
+	// How long a stream can be read after its items were posted.
+	streamTicketTTL = time.Minute
+	// How many posted streams can wait to be read at the same time.
+	maxPendingStreams = 1000
+)
+
+// StreamTicket is the response of POST /cofacts/stream. The results can be
+// read from GET /cofacts/stream?id=<id> within ExpiresIn seconds.
+type StreamTicket struct {
+	Id        string `json:"id"`
+	ExpiresIn int    `json:"expiresIn"`
+}
+
+// pendingStream holds the posted items of a stream until it is read.
+type pendingStream struct {
+	items   []BatchItem
+	config  MatchConfig
+	debug   bool
+	expires time.Time
+}
+
+// pendingStreams are the streams that were posted but not read yet, by id.
+var pendingStreams = struct {
+	sync.Mutex
+	streams map[string]*pendingStream
+}{streams: make(map[string]*pendingStream)}
+
+// addPendingStream stores a stream and returns its id. If too many are
+// waiting, the oldest one is dropped.
+func addPendingStream(stream *pendingStream) string {
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+
+	pendingStreams.Lock()
+	defer pendingStreams.Unlock()
+	now := time.Now()
+	oldest := ""
+	for key, s := range pendingStreams.streams {
+		if now.After(s.expires) {
+			delete(pendingStreams.streams, key)
+		} else if oldest == "" || s.expires.Before(pendingStreams.streams[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(pendingStreams.streams) >= maxPendingStreams {
+		delete(pendingStreams.streams, oldest)
+	}
+	pendingStreams.streams[id] = stream
+	return id
+}
+
+// takePendingStream removes the stream with the id and returns it, or nil if
+// there is none or it expired. A stream can only be read once.
+func takePendingStream(id string) *pendingStream {
+	pendingStreams.Lock()
+	defer pendingStreams.Unlock()
+	stream, ok := pendingStreams.streams[id]
+	if !ok {
+		return nil
+	}
+	delete(pendingStreams.streams, id)
+	if time.Now().After(stream.expires) {
+		return nil
+	}
+	return stream
+}
+
+// handleCofactsStreamPost takes the same items as handleCofactsBatch and
+// answers with a StreamTicket, to read the results from with
+// handleCofactsStream. The browser's EventSource can only make GET requests
+// without a body, so the items have to be posted first.
+func handleCofactsStreamPost(c *gin.Context) {
+	config, err := matchConfig.withOverrides(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, invalidInput(err))
+		return
+	}
+	var items []BatchItem
+	if err := c.ShouldBindJSON(&items); err != nil {
+		respondError(c, invalidInput(err))
+		return
+	}
+	if len(items) > maxBatchSize {
+		respondError(c, invalidInput(
+			fmt.Errorf("a stream can have at most %d items, got %d", maxBatchSize, len(items))))
+		return
+	}
+	_, debug := c.GetQuery("debug")
+
+	id := addPendingStream(&pendingStream{
+		items:   items,
+		config:  config,
+		debug:   debug,
+		expires: time.Now().Add(streamTicketTTL),
+	})
+	c.JSON(http.StatusOK, StreamTicket{Id: id, ExpiresIn: int(streamTicketTTL / time.Second)})
+}
+
+// handleCofactsStream checks the items posted for the stream with the id in
+// the query, and sends the result of each as a Server-Sent Event as soon as
+// it is ready, instead of waiting for the slowest one. The events are
+// "result" with a BatchResult and finally "summary" with a StreamSummary.
+// When the client disconnects, the lookups that are still running are
+// cancelled.
+//
+// A stream can only be read once, so when EventSource reconnects after the
+// summary it gets a 404 and stops; clients should close it on the summary.
+func handleCofactsStream(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		respondError(c, invalidInput(errors.New("the id of the stream is missing")))
+		return
+	}
+	stream := takePendingStream(id)
+	if stream == nil {
+		respondError(c, &APIError{
+			Code:    ErrNotFound,
+			Message: fmt.Sprintf("there is no stream %q, or it was already read or expired", id),
+			Status:  http.StatusNotFound,
+		})
+		return
+	}
+	items, config, debug := stream.items, stream.config, stream.debug
+
+	ctx := c.Request.Context()
+	start := time.Now()
+	results := make(chan BatchResult)
+	go func() {
+		checkBatch(ctx, items, config, func(result BatchResult) {
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		})
+		close(results)
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	summary := StreamSummary{Items: len(items)}
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				summary.ElapsedMs = int64(time.Since(start) / time.Millisecond)
+				sse.Encode(w, sse.Event{Event: "summary", Data: summary})
+				return false
+			}
+			if result.Error != nil {
+				result.Error = withRequestId(c, result.Error)
+				summary.Errors++
+			} else if hasMatch(result.Result) {
+				summary.Matched++
+			}
+			if result.Result != nil && (debug || DEBUG) {
+				result.Result.Config = &config
+			}
+			sse.Encode(w, sse.Event{Event: "result", Id: result.Id, Data: result})
+			return true
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+func hasMatch(resp *CofactResponse) bool {
+	for _, edge := range resp.Data.ListArticles.Edges {
+		if edge.Node.IsMatch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sse"
+)
+
+type sseEvent struct {
+	Event, Id, Data string
+}
+
+// parseSse splits a Server-Sent Events stream into its events and counts
+// the comment lines, which the server sends as heartbeats.
+func parseSse(body string) (events []sseEvent, comments int) {
+	for _, block := range strings.Split(body, "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, ":"):
+				comments++
+			case strings.HasPrefix(line, "event:"):
+				event.Event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "id:"):
+				event.Id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+			case strings.HasPrefix(line, "data:"):
+				event.Data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			}
+		}
+		if event.Event != "" {
+			events = append(events, event)
+		}
+	}
+	return events, comments
+}
+
+// postStream posts the items of a stream and returns its id.
+func postStream(t *testing.T, serverUrl string, items string) string {
+	resp, err := http.Post(serverUrl+"/cofacts/stream", "application/json", strings.NewReader(items))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var ticket StreamTicket
+	if err := json.NewDecoder(resp.Body).Decode(&ticket); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || ticket.Id == "" || ticket.ExpiresIn != 60 {
+		t.Fatalf("posting the items: got %s, %+v", resp.Status, ticket)
+	}
+	return ticket.Id
+}
+
+func TestCofactsStream(t *testing.T) {
+	savedHeartbeat := streamHeartbeat
+	streamHeartbeat = 10 * time.Millisecond
+	defer func() { streamHeartbeat = savedHeartbeat }()
+
+	// Slow enough for a few heartbeats before the results
+	router, restore := newTestRouter(&fakeBackend{Articles: testArticles, Delay: 80 * time.Millisecond})
+	defer restore()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	id := postStream(t, server.URL, `[{"id": "a", "text": "緊急通知！喝溫開水可以預防新型冠狀病毒，請大家轉傳給親朋好友。"},
+		{"id": "b", "text": "完全無關的另一段文字內容"},
+		{"id": "c", "text": "  "}]`)
+	resp, err := http.Get(server.URL + "/cofacts/stream?id=" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != sse.ContentType {
+		t.Fatalf("got %s with content type %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+
+	events, comments := parseSse(body)
+	if comments == 0 {
+		t.Errorf("no heartbeat while the lookups were running")
+	}
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 3 results and a summary:\n%s", len(events), body)
+	}
+	results := make(map[string]BatchResult)
+	for _, event := range events[:3] {
+		var result BatchResult
+		if event.Event != "result" {
+			t.Fatalf("got a %s event before the results were done", event.Event)
+		}
+		if err := json.Unmarshal([]byte(event.Data), &result); err != nil {
+			t.Fatal(err)
+		}
+		if event.Id != result.Id {
+			t.Errorf("event id %q for the result of %q", event.Id, result.Id)
+		}
+		results[result.Id] = result
+	}
+	if r := results["a"]; r.Result == nil || !hasMatch(r.Result) || r.Result.Verdict.Verdict != ReplyRumor {
+		t.Errorf("result a = %+v", r)
+	}
+	if r := results["b"]; r.Result == nil || hasMatch(r.Result) {
+		t.Errorf("result b = %+v", r)
+	}
+	if r := results["c"]; r.Error == nil || r.Error.Code != ErrInvalidInput || r.Error.RequestId == "" {
+		t.Errorf("result c = %+v", r)
+	}
+
+	var summary StreamSummary
+	if events[3].Event != "summary" {
+		t.Fatalf("the last event is %s", events[3].Event)
+	}
+	if err := json.Unmarshal([]byte(events[3].Data), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Items != 3 || summary.Matched != 1 || summary.Errors != 1 || summary.ElapsedMs < 80 {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	// Like when EventSource reconnects after the summary
+	again, err := http.Get(server.URL + "/cofacts/stream?id=" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Body.Close()
+	if again.StatusCode != http.StatusNotFound {
+		t.Errorf("reading the stream again: got %s", again.Status)
+	}
+}
+
+func TestCofactsStreamInvalidInput(t *testing.T) {
+	router, restore := newTestRouter(&fakeBackend{Articles: testArticles})
+	defer restore()
+
+	for _, body := range []string{`{"id": "a"}`, `[` + strings.Repeat(`{"id": "x", "text": "y"},`, maxBatchSize) + `{}]`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cofacts/stream", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%.20s: got %d, want 400", body, w.Code)
+		}
+	}
+
+	expired := addPendingStream(&pendingStream{
+		items:   []BatchItem{{Id: "a", Text: "b"}},
+		config:  defaultMatchConfig(),
+		expires: time.Now().Add(-time.Second),
+	})
+	for _, test := range []struct {
+		query  string
+		status int
+		code   string
+	}{
+		{"", http.StatusBadRequest, ErrInvalidInput},
+		{"?id=", http.StatusBadRequest, ErrInvalidInput},
+		{"?id=unknown", http.StatusNotFound, ErrNotFound},
+		{"?id=" + expired, http.StatusNotFound, ErrNotFound},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cofacts/stream"+test.query, nil))
+		var resp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != test.status || resp.Error == nil || resp.Error.Code != test.code {
+			t.Errorf("GET /cofacts/stream%s: got %d %s", test.query, w.Code, w.Body)
+		}
+	}
+}
+
+// TestCofactsStreamDisconnect checks that the lookups of a stream, and the
+// upstream calls they share through the cache, are cancelled when the client
+// goes away.
+func TestCofactsStreamDisconnect(t *testing.T) {
+	savedHeartbeat := streamHeartbeat
+	streamHeartbeat = 10 * time.Millisecond
+	defer func() { streamHeartbeat = savedHeartbeat }()
+
+	backend := &slowBackend{release: make(chan struct{})}
+	defer close(backend.release)
+	router, restore := newTestRouter(newCachedBackend(backend, 10))
+	defer restore()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	id := postStream(t, server.URL, `[{"id": "a", "text": "第一則訊息"}, {"id": "b", "text": "第二則訊息"}]`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/cofacts/stream?id="+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Disconnect after the first heartbeat, while the lookups are running
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, ":") {
+		t.Fatalf("got %q, %v, want a heartbeat", line, err)
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != 2 {
+		t.Errorf("%d upstream calls, want 2", n)
+	}
+	cancel()
+
+	waitFor(t, "the upstream calls to be cancelled", func() bool {
+		return atomic.LoadInt32(&backend.cancelled) == 2
+	})
+}
+
+func TestAddPendingStream(t *testing.T) {
+	pendingStreams.Lock()
+	saved := pendingStreams.streams
+	pendingStreams.streams = make(map[string]*pendingStream)
+	pendingStreams.Unlock()
+	defer func() {
+		pendingStreams.Lock()
+		pendingStreams.streams = saved
+		pendingStreams.Unlock()
+	}()
+
+	now := time.Now()
+	oldest := addPendingStream(&pendingStream{expires: now.Add(time.Second)})
+	expired := addPendingStream(&pendingStream{expires: now.Add(-time.Second)})
+	for i := 2; i < maxPendingStreams; i++ {
+		addPendingStream(&pendingStream{expires: now.Add(time.Minute)})
+	}
+	// The expired stream makes room for this one, the next drops the oldest
+	newest := addPendingStream(&pendingStream{expires: now.Add(2 * time.Minute)})
+	if len(pendingStreams.streams) != maxPendingStreams || pendingStreams.streams[oldest] == nil ||
+		pendingStreams.streams[expired] != nil {
+		t.Errorf("%d streams pending after filling up", len(pendingStreams.streams))
+	}
+	addPendingStream(&pendingStream{expires: now.Add(2 * time.Minute)})
+	if len(pendingStreams.streams) != maxPendingStreams || pendingStreams.streams[oldest] != nil {
+		t.Errorf("the oldest stream wasn't dropped")
+	}
+
+	if takePendingStream(newest) == nil || takePendingStream(newest) != nil {
+		t.Errorf("a stream can be taken other than exactly once")
+	}
+}
@@ -0,0 +1,252 @@
+// Command gqlgen generates Go code for the Cofacts GraphQL schema from the
+// result of an introspection query: the enums, and a table of the fields of
+// every object type that the server's query is checked against, so it doesn't
+// drift from the schema.
+//
+// Run it through go generate in the repository root. To update the checked
+// in schema from the api first:
+//
+//	go run ./tools/gqlgen -fetch https://cofacts-api.g0v.tw/graphql -schema cofacts_schema.json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The standard introspection query, limited to what the generator uses.
+const introspectionQuery = `
+query {
+  __schema {
+    queryType { name }
+    types {
+      kind
+      name
+      description
+      fields(includeDeprecated: true) {
+        name
+        description
+        args { name description type { ...TypeRef } defaultValue }
+        type { ...TypeRef }
+      }
+      inputFields { name description type { ...TypeRef } defaultValue }
+      enumValues(includeDeprecated: true) { name description }
+    }
+  }
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } }
+}`
+
+type typeRef struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	OfType *typeRef `json:"ofType"`
+}
+
+// named returns the name of the type without its list and non-null
+// wrappers, and whether it is a list.
+func (t *typeRef) named() (name string, list bool, nonNull bool) {
+	if t.Kind == "NON_NULL" {
+		name, list, _ = t.OfType.named()
+		return name, list, true
+	}
+	if t.Kind == "LIST" {
+		name, _, _ = t.OfType.named()
+		return name, true, false
+	}
+	return t.Name, false, false
+}
+
+type inputValue struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Type        typeRef `json:"type"`
+}
+
+type field struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Args        []inputValue `json:"args"`
+	Type        typeRef      `json:"type"`
+}
+
+type enumValue struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type fullType struct {
+	Kind        string       `json:"kind"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Fields      []field      `json:"fields"`
+	InputFields []inputValue `json:"inputFields"`
+	EnumValues  []enumValue  `json:"enumValues"`
+}
+
+type schema struct {
+	QueryType struct {
+		Name string `json:"name"`
+	} `json:"queryType"`
+	Types []fullType `json:"types"`
+}
+
+type introspection struct {
+	Data struct {
+		Schema schema `json:"__schema"`
+	} `json:"data"`
+}
+
+func main() {
+	schemaPath := flag.String("schema", "cofacts_schema.json", "introspection result to read")
+	outPath := flag.String("out", "cofacts_schema_gen.go", "Go file to write")
+	fetch := flag.String("fetch", "", "GraphQL endpoint to fetch the schema from, written to -schema")
+	flag.Parse()
+
+	if *fetch != "" {
+		if err := fetchSchema(*fetch, *schemaPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	data, err := ioutil.ReadFile(*schemaPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var result introspection
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Fatalf("%s: %v", *schemaPath, err)
+	}
+
+	src, err := format.Source(generate(&result.Data.Schema, *schemaPath))
+	if err != nil {
+		log.Fatal("generated invalid Go: ", err)
+	}
+	if err := ioutil.WriteFile(*outPath, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func fetchSchema(endpoint string, path string) error {
+	body, err := json.Marshal(map[string]string{"query": introspectionQuery})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, data)
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, indented.Bytes(), 0644)
+}
+
+// goName turns a GraphQL name into an exported Go name, keeping the
+// spelling of the rest of the server, such as Id and Url.
+func goName(name string) string {
+	name = strings.TrimLeft(name, "_")
+	if name == "" {
+		return "X"
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// enumConstName turns an enum value such as NOT_RUMOR into NotRumor.
+func enumConstName(value string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(strings.ToLower(value), "_") {
+		b.WriteString(goName(part))
+	}
+	return b.String()
+}
+
+func typeName(name string) string {
+	return "Gql" + name
+}
+
+func writeComment(b *bytes.Buffer, indent string, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fmt.Fprintf(b, "%s// %s\n", indent, strings.TrimSpace(line))
+	}
+}
+
+func generate(s *schema, schemaPath string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by tools/gqlgen from %s. DO NOT EDIT.\n\n", schemaPath)
+	b.WriteString("package main\n\n")
+
+	types := append([]fullType(nil), s.Types...)
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+	fmt.Fprintf(&b, "const gqlQueryType = %q\n\n", s.QueryType.Name)
+
+	for _, t := range types {
+		if t.Kind != "ENUM" || strings.HasPrefix(t.Name, "__") {
+			continue
+		}
+		if t.Description != "" {
+			writeComment(&b, "", t.Description)
+		}
+		fmt.Fprintf(&b, "type %s string\n\nconst (\n", typeName(t.Name))
+		prefix := typeName(strings.TrimSuffix(t.Name, "Enum"))
+		for _, v := range t.EnumValues {
+			if v.Description != "" {
+				writeComment(&b, "\t", v.Description)
+			}
+			fmt.Fprintf(&b, "\t%s%s %s = %q\n", prefix, enumConstName(v.Name), typeName(t.Name), v.Name)
+		}
+		b.WriteString(")\n\n")
+	}
+
+	b.WriteString("// gqlSchema has the fields of every object type, to check queries\n")
+	b.WriteString("// against, see validateGqlQuery.\n")
+	b.WriteString("var gqlSchema = map[string]map[string]gqlFieldDef{\n")
+	for _, t := range types {
+		if t.Kind != "OBJECT" || strings.HasPrefix(t.Name, "__") {
+			continue
+		}
+		fmt.Fprintf(&b, "\t%q: {\n", t.Name)
+		for _, f := range t.Fields {
+			name, list, _ := f.Type.named()
+			fmt.Fprintf(&b, "\t\t%q: {Type: %q, List: %v", f.Name, name, list)
+			if len(f.Args) > 0 {
+				args := make([]string, len(f.Args))
+				for i, a := range f.Args {
+					args[i] = fmt.Sprintf("%q", a.Name)
+				}
+				fmt.Fprintf(&b, ", Args: []string{%s}", strings.Join(args, ", "))
+			}
+			b.WriteString("},\n")
+		}
+		b.WriteString("\t},\n")
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The /v2 api has its own response schema, so the extension doesn't depend on
+// the shape of the Cofacts GraphQL api like it does with /cofacts. Fields are
+// only ever added to these types; anything else needs a /v3.
+
+// CheckRequest is the body of POST /v2/check.
+type CheckRequest struct {
+	// Chosen by the client and returned in the response. If empty, the
+	// response has an id derived from the normalized text.
+	Id   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// CheckResponse is the result of checking a text.
+type CheckResponse struct {
+	Id string `json:"id"`
+
+	// The text as it was compared with the articles, see textNormalizer.
+	NormalizedQuery string `json:"normalizedQuery"`
+
+	// The articles that match the text, best first.
+	Matches []CheckMatch `json:"matches"`
+
+	// The verdict of the replies to all matches, missing if none of them
+	// have replies.
+	Verdict *Verdict `json:"verdict,omitempty"`
+
+	Backend CheckBackend `json:"backend"`
+
+	// The effective match configuration, only with the debug parameter.
+	Config *MatchConfig `json:"config,omitempty"`
+}
+
+// CheckMatch is an article that matches the text.
+type CheckMatch struct {
+	ArticleId         string  `json:"articleId"`
+	ArticleUrl        string  `json:"articleUrl"`
+	Text              string  `json:"text"`
+	CreatedAt         string  `json:"createdAt,omitempty"`
+	ReplyRequestCount int     `json:"replyRequestCount"`
+	Score             float64 `json:"score"`
+	Strategy          string  `json:"strategy"`
+
+	// The part of the text that was found in the article, if any.
+	Span *CheckSpan `json:"span,omitempty"`
+
+	Verdict *Verdict     `json:"verdict,omitempty"`
+	Replies []CheckReply `json:"replies"`
+}
+
+// CheckSpan is what matched in the text and the article. Offsets count
+// characters, with the end being exclusive, and are -1 if the matched part
+// doesn't appear literally in that text.
+type CheckSpan struct {
+	Text         string   `json:"text,omitempty"`
+	QueryUrl     string   `json:"queryUrl,omitempty"`
+	ArticleUrl   string   `json:"articleUrl,omitempty"`
+	UrlRules     []string `json:"urlRules,omitempty"`
+	QueryStart   int      `json:"queryStart"`
+	QueryEnd     int      `json:"queryEnd"`
+	ArticleStart int      `json:"articleStart"`
+	ArticleEnd   int      `json:"articleEnd"`
+}
+
+// CheckReply is a reply to a matching article, in order of user feedback.
+type CheckReply struct {
+	Id                    string `json:"id"`
+	Type                  string `json:"type"`
+	Text                  string `json:"text"`
+	Reference             string `json:"reference,omitempty"`
+	CreatedAt             string `json:"createdAt,omitempty"`
+	PositiveFeedbackCount int    `json:"positiveFeedbackCount"`
+	NegativeFeedbackCount int    `json:"negativeFeedbackCount"`
+}
+
+// CheckBackend describes where the articles came from.
+type CheckBackend struct {
+	// "cofacts" or "offline"
+	Name string `json:"name"`
+
+	// The number of articles the text was compared with.
+	Candidates int   `json:"candidates"`
+	ElapsedMs  int64 `json:"elapsedMs"`
+}
+
+const cofactsArticleUrl = "https://cofacts.tw/article/"
+
+// The name of the backend for CheckBackend, set up in main.
+var backendName = "cofacts"
+
+// newCheckResponse converts the result of checkText to the /v2 schema.
+func newCheckResponse(id string, text string, respData *CofactResponse, elapsed time.Duration) *CheckResponse {
+	normalized := textNormalizer.normalize(text).String()
+	if id == "" {
+		sum := sha1.Sum([]byte(cacheKey(ArticleQuery{Text: text})))
+		id = hex.EncodeToString(sum[:8])
+	}
+	resp := &CheckResponse{
+		Id:              id,
+		NormalizedQuery: normalized,
+		Matches:         []CheckMatch{},
+		Verdict:         respData.Verdict,
+		Backend: CheckBackend{
+			Name:       backendName,
+			Candidates: len(respData.Data.ListArticles.Edges),
+			ElapsedMs:  int64(elapsed / time.Millisecond),
+		},
+	}
+
+	for _, edge := range respData.Data.ListArticles.Edges {
+		node := edge.Node
+		if !node.IsMatch {
+			continue
+		}
+		match := CheckMatch{
+			ArticleId:         node.Id,
+			ArticleUrl:        cofactsArticleUrl + node.Id,
+			Text:              node.Text,
+			CreatedAt:         node.CreatedAt,
+			ReplyRequestCount: node.ReplyRequestCount,
+			Score:             node.Score,
+			Strategy:          node.Strategy,
+			Verdict:           node.Verdict,
+			Replies:           make([]CheckReply, len(node.ArticleReplies)),
+		}
+		if m := node.Match; m != nil {
+			match.Span = &CheckSpan{
+				Text:         m.Text,
+				QueryUrl:     m.QueryUrl,
+				ArticleUrl:   m.ArticleUrl,
+				UrlRules:     m.UrlRules,
+				QueryStart:   m.QueryStart,
+				QueryEnd:     m.QueryEnd,
+				ArticleStart: m.ArticleStart,
+				ArticleEnd:   m.ArticleEnd,
+			}
+		}
+		for i, ar := range node.ArticleReplies {
+			match.Replies[i] = CheckReply{
+				Id:                    ar.Reply.Id,
+				Type:                  ar.Reply.Type,
+				Text:                  ar.Reply.Text,
+				Reference:             ar.Reply.Reference,
+				CreatedAt:             ar.Reply.CreatedAt,
+				PositiveFeedbackCount: ar.PositiveFeedbackCount,
+				NegativeFeedbackCount: ar.NegativeFeedbackCount,
+			}
+		}
+		resp.Matches = append(resp.Matches, match)
+	}
+	sort.SliceStable(resp.Matches, func(i, j int) bool {
+		return resp.Matches[i].Score > resp.Matches[j].Score
+	})
+	return resp
+}
+
+// handleCheck is POST /v2/check, which takes a CheckRequest and answers with
+// a CheckResponse.
+func handleCheck(c *gin.Context) {
+	config, err := matchConfig.withOverrides(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, invalidInput(err))
+		return
+	}
+	var req CheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidInput(err))
+		return
+	}
+
+	start := time.Now()
+	respData, err := checkText(c.Request.Context(), req.Text, config)
+	if err != nil {
+		respondError(c, classifyError(err))
+		return
+	}
+
+	resp := newCheckResponse(req.Id, req.Text, respData, time.Since(start))
+	if _, ok := c.GetQuery("debug"); ok || DEBUG {
+		resp.Config = &config
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewCheckResponse(t *testing.T) {
+	respData := &CofactResponse{}
+	respData.Verdict = &Verdict{Counts: map[string]int{ReplyRumor: 1}, Verdict: ReplyRumor, Confidence: 1}
+	for _, node := range []Node{
+		{Id: "unmatched", Score: 0.95},
+		{Id: "tie1", IsMatch: true, Score: 0.5, Strategy: StrategyTfidf},
+		{
+			Id:                "best",
+			Text:              "喝溫開水可以預防新型冠狀病毒",
+			CreatedAt:         "2020-02-01T08:00:00.000Z",
+			ReplyRequestCount: 12,
+			IsMatch:           true,
+			Score:             0.9,
+			Strategy:          StrategyUrl,
+			Match: &MatchExplanation{
+				QueryUrl:     "http://example.com/a?utm_source=line",
+				ArticleUrl:   "https://example.com/a",
+				UrlRules:     []string{"tracking"},
+				QueryStart:   2,
+				QueryEnd:     38,
+				ArticleStart: -1,
+				ArticleEnd:   -1,
+			},
+			Verdict: &Verdict{Counts: map[string]int{ReplyRumor: 1}, Verdict: ReplyRumor, Confidence: 1},
+			ArticleReplies: []ArticleReplies{{
+				Reply: ArticleReply{
+					Id:        "r1",
+					Text:      "喝水無法預防病毒。",
+					Type:      ReplyRumor,
+					Reference: "https://www.mohw.gov.tw/",
+					CreatedAt: "2020-02-02T00:00:00.000Z",
+				},
+				Status:                "NORMAL",
+				PositiveFeedbackCount: 3,
+				NegativeFeedbackCount: 1,
+			}},
+		},
+		{Id: "tie2", IsMatch: true, Score: 0.5, Strategy: StrategyNearDuplicate},
+		{Id: "worst", IsMatch: true, Score: 0.45, Strategy: StrategyLcs},
+		{Id: "unmatched2"},
+	} {
+		respData.Data.ListArticles.Edges = append(respData.Data.ListArticles.Edges, Edge{Node: node})
+	}
+
+	resp := newCheckResponse("post-1", "ＡＢＣ 喝溫開水！", respData, 1500*time.Millisecond)
+	if resp.Id != "post-1" || resp.NormalizedQuery != "abc 喝溫開水" {
+		t.Errorf("id %q, normalized query %q", resp.Id, resp.NormalizedQuery)
+	}
+	if resp.Verdict != respData.Verdict {
+		t.Errorf("verdict %+v, want the overall verdict", resp.Verdict)
+	}
+	if resp.Backend != (CheckBackend{Name: backendName, Candidates: 6, ElapsedMs: 1500}) {
+		t.Errorf("backend %+v", resp.Backend)
+	}
+
+	// Only the matching articles, best first, keeping the order of ties
+	var ids []string
+	for _, match := range resp.Matches {
+		ids = append(ids, match.ArticleId)
+	}
+	if want := []string{"best", "tie1", "tie2", "worst"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("matches %q, want %q", ids, want)
+	}
+
+	want := CheckMatch{
+		ArticleId:         "best",
+		ArticleUrl:        "https://cofacts.tw/article/best",
+		Text:              "喝溫開水可以預防新型冠狀病毒",
+		CreatedAt:         "2020-02-01T08:00:00.000Z",
+		ReplyRequestCount: 12,
+		Score:             0.9,
+		Strategy:          StrategyUrl,
+		Span: &CheckSpan{
+			QueryUrl:     "http://example.com/a?utm_source=line",
+			ArticleUrl:   "https://example.com/a",
+			UrlRules:     []string{"tracking"},
+			QueryStart:   2,
+			QueryEnd:     38,
+			ArticleStart: -1,
+			ArticleEnd:   -1,
+		},
+		Verdict: respData.Data.ListArticles.Edges[2].Node.Verdict,
+		Replies: []CheckReply{{
+			Id:                    "r1",
+			Type:                  ReplyRumor,
+			Text:                  "喝水無法預防病毒。",
+			Reference:             "https://www.mohw.gov.tw/",
+			CreatedAt:             "2020-02-02T00:00:00.000Z",
+			PositiveFeedbackCount: 3,
+			NegativeFeedbackCount: 1,
+		}},
+	}
+	if !reflect.DeepEqual(resp.Matches[0], want) {
+		t.Errorf("best match = %+v, want %+v", resp.Matches[0], want)
+	}
+	if m := resp.Matches[1]; m.Span != nil || m.Verdict != nil || m.Replies == nil || len(m.Replies) != 0 {
+		t.Errorf("match without explanation or replies = %+v", m)
+	}
+
+	// Without matches the list is empty rather than null, and without an id
+	// one is derived from the text, the same for texts that only differ in
+	// normalization
+	empty := &CofactResponse{}
+	empty.Data.ListArticles.Edges = []Edge{{Node: Node{Id: "unmatched"}}}
+	a := newCheckResponse("", "ＡＢＣ 喝溫開水！", empty, 0)
+	b := newCheckResponse("", "abc喝溫開水", empty, 0)
+	c := newCheckResponse("", "abc喝冷開水", empty, 0)
+	if a.Id == "" || a.Id != b.Id || a.Id == c.Id {
+		t.Errorf("derived ids %q, %q and %q", a.Id, b.Id, c.Id)
+	}
+	body, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if matches, ok := decoded["matches"].([]interface{}); !ok || len(matches) != 0 {
+		t.Errorf("matches without a match = %v", decoded["matches"])
+	}
+	if _, ok := decoded["verdict"]; ok {
+		t.Errorf("verdict without a match = %v", decoded["verdict"])
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import "sort"
+
+// The types of reply Cofacts editors can give to an article.
+const (
+	ReplyRumor       = string(GqlReplyTypeRumor)
+	ReplyNotRumor    = string(GqlReplyTypeNotRumor)
+	ReplyOpinionated = string(GqlReplyTypeOpinionated)
+	ReplyNotArticle  = string(GqlReplyTypeNotArticle)
+)
+
+// Reply types in order of precedence, used to break ties between equally
+// common types. A rumor warning is the safer badge to show.
+var replyTypes = []string{ReplyRumor, ReplyOpinionated, ReplyNotRumor, ReplyNotArticle}
+
+// Verdict summarizes the replies to one or more articles, so the extension
+// doesn't need to work out the overall verdict itself.
+type Verdict struct {
+	// The number of replies of each type.
+	Counts map[string]int `json:"counts"`
+
+	// The most common reply type, or empty if there are no replies of a
+	// known type.
+	Verdict string `json:"verdict,omitempty"`
+
+	// The fraction of the replies that agree with the verdict, from 0 to 1.
+	Confidence float64 `json:"confidence"`
+
+	// Whether the replies disagree, that is they have more than one type.
+	Conflicting bool `json:"conflicting"`
+}
+
+func (verdict *Verdict) add(replyType string, count int) {
+	if verdict.Counts == nil {
+		verdict.Counts = make(map[string]int)
+	}
+	verdict.Counts[replyType] += count
+}
+
+// decide sets the verdict, confidence and conflict flag from the counts.
+func (verdict *Verdict) decide() {
+	total, best, types := 0, 0, 0
+	for _, n := range verdict.Counts {
+		total += n
+		if n > 0 {
+			types++
+		}
+	}
+	verdict.Verdict = ""
+	for _, t := range replyTypes {
+		if n := verdict.Counts[t]; n > best {
+			verdict.Verdict, best = t, n
+		}
+	}
+	verdict.Confidence = 0
+	if total > 0 {
+		verdict.Confidence = float64(best) / float64(total)
+	}
+	verdict.Conflicting = types > 1
+}
+
+// orderReplies drops the replies that were deleted and puts the rest in
+// order of the feedback users gave on them, best first, instead of the order
+// Cofacts returns them in. Replies with the same balance of feedback keep
+// their order.
+func orderReplies(node *Node) {
+	replies := make([]ArticleReplies, 0, len(node.ArticleReplies))
+	for _, ar := range node.ArticleReplies {
+		if ar.Status == "" || ar.Status == "NORMAL" {
+			replies = append(replies, ar)
+		}
+	}
+	sort.SliceStable(replies, func(i, j int) bool {
+		a, b := replies[i], replies[j]
+		balanceA := a.PositiveFeedbackCount - a.NegativeFeedbackCount
+		balanceB := b.PositiveFeedbackCount - b.NegativeFeedbackCount
+		if balanceA != balanceB {
+			return balanceA > balanceB
+		}
+		return a.PositiveFeedbackCount > b.PositiveFeedbackCount
+	})
+	node.ArticleReplies = replies
+}
+
+// nodeVerdict aggregates the replies to a single article.
+func nodeVerdict(node *Node) *Verdict {
+	if len(node.ArticleReplies) == 0 {
+		return nil
+	}
+	verdict := &Verdict{}
+	for _, ar := range node.ArticleReplies {
+		verdict.add(ar.Reply.Type, 1)
+	}
+	verdict.decide()
+	return verdict
+}
+
+// addVerdicts sets the verdict of every article, and the overall verdict
+// from the replies to the articles that match the query.
+func addVerdicts(respData *CofactResponse) {
+	var overall *Verdict
+	for i := range respData.Data.ListArticles.Edges {
+		node := &respData.Data.ListArticles.Edges[i].Node
+		node.Verdict = nodeVerdict(node)
+		if !node.IsMatch || node.Verdict == nil {
+			continue
+		}
+		if overall == nil {
+			overall = &Verdict{}
+		}
+		for t, n := range node.Verdict.Counts {
+			overall.add(t, n)
+		}
+	}
+	if overall != nil {
+		overall.decide()
+	}
+	respData.Verdict = overall
+}
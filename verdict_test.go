@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerdictDecide(t *testing.T) {
+	tests := []struct {
+		name        string
+		counts      map[string]int
+		verdict     string
+		confidence  float64
+		conflicting bool
+	}{
+		{"no replies", nil, "", 0, false},
+		{"zero counts", map[string]int{ReplyRumor: 0}, "", 0, false},
+		{"unanimous", map[string]int{ReplyNotRumor: 3}, ReplyNotRumor, 1, false},
+		{"majority", map[string]int{ReplyRumor: 3, ReplyNotRumor: 1}, ReplyRumor, 0.75, true},
+		{"tie between rumor and not rumor", map[string]int{ReplyNotRumor: 2, ReplyRumor: 2}, ReplyRumor, 0.5, true},
+		{"tie between opinionated and not rumor", map[string]int{ReplyNotRumor: 1, ReplyOpinionated: 1},
+			ReplyOpinionated, 0.5, true},
+		{"tie between not rumor and not article", map[string]int{ReplyNotArticle: 1, ReplyNotRumor: 1},
+			ReplyNotRumor, 0.5, true},
+		{"tie of all types", map[string]int{ReplyNotArticle: 1, ReplyNotRumor: 1, ReplyOpinionated: 1, ReplyRumor: 1},
+			ReplyRumor, 0.25, true},
+		// Unknown types count towards the total, but are never the verdict
+		{"only unknown", map[string]int{"SATIRE": 2}, "", 0, false},
+		{"more unknown than known", map[string]int{"SATIRE": 3, ReplyNotRumor: 1}, ReplyNotRumor, 0.25, true},
+	}
+	for _, test := range tests {
+		verdict := &Verdict{Counts: test.counts}
+		verdict.decide()
+		if verdict.Verdict != test.verdict || verdict.Confidence != test.confidence ||
+			verdict.Conflicting != test.conflicting {
+			t.Errorf("%s: got %q, confidence %v, conflicting %v, want %q, %v, %v", test.name,
+				verdict.Verdict, verdict.Confidence, verdict.Conflicting,
+				test.verdict, test.confidence, test.conflicting)
+		}
+	}
+}
+
+func TestAddVerdicts(t *testing.T) {
+	replies := func(types ...string) []ArticleReplies {
+		var replies []ArticleReplies
+		for _, t := range types {
+			replies = append(replies, ArticleReplies{Reply: ArticleReply{Type: t}, Status: "NORMAL"})
+		}
+		return replies
+	}
+	tests := []struct {
+		name     string
+		articles []Node
+		// The verdicts of the articles, nil for articles without replies
+		verdicts []string
+		overall  *Verdict
+	}{
+		{
+			name:    "no articles",
+			overall: nil,
+		},
+		{
+			name: "no matches",
+			articles: []Node{
+				{Id: "a", ArticleReplies: replies(ReplyRumor)},
+			},
+			verdicts: []string{ReplyRumor},
+			overall:  nil,
+		},
+		{
+			name: "matches without replies",
+			articles: []Node{
+				{Id: "a", IsMatch: true},
+				{Id: "b", IsMatch: true, ArticleReplies: []ArticleReplies{}},
+			},
+			verdicts: []string{"", ""},
+			overall:  nil,
+		},
+		{
+			name: "unmatched articles are left out",
+			articles: []Node{
+				{Id: "a", IsMatch: true, ArticleReplies: replies(ReplyNotRumor)},
+				{Id: "b", ArticleReplies: replies(ReplyRumor, ReplyRumor)},
+				{Id: "c", IsMatch: true, ArticleReplies: replies(ReplyNotRumor, ReplyOpinionated)},
+			},
+			verdicts: []string{ReplyNotRumor, ReplyRumor, ReplyOpinionated},
+			overall: &Verdict{
+				Counts:      map[string]int{ReplyNotRumor: 2, ReplyOpinionated: 1},
+				Verdict:     ReplyNotRumor,
+				Confidence:  2.0 / 3,
+				Conflicting: true,
+			},
+		},
+		{
+			name: "tie across articles",
+			articles: []Node{
+				{Id: "a", IsMatch: true, ArticleReplies: replies(ReplyNotRumor)},
+				{Id: "b", IsMatch: true, ArticleReplies: replies(ReplyRumor)},
+			},
+			verdicts: []string{ReplyNotRumor, ReplyRumor},
+			overall: &Verdict{
+				Counts:      map[string]int{ReplyNotRumor: 1, ReplyRumor: 1},
+				Verdict:     ReplyRumor,
+				Confidence:  0.5,
+				Conflicting: true,
+			},
+		},
+		{
+			name: "unknown types",
+			articles: []Node{
+				{Id: "a", IsMatch: true, ArticleReplies: replies("SATIRE")},
+				{Id: "b", IsMatch: true, ArticleReplies: replies(ReplyRumor)},
+			},
+			verdicts: []string{"", ReplyRumor},
+			overall: &Verdict{
+				Counts:      map[string]int{"SATIRE": 1, ReplyRumor: 1},
+				Verdict:     ReplyRumor,
+				Confidence:  0.5,
+				Conflicting: true,
+			},
+		},
+	}
+	for _, test := range tests {
+		resp := &CofactResponse{}
+		for _, node := range test.articles {
+			resp.Data.ListArticles.Edges = append(resp.Data.ListArticles.Edges, Edge{Node: node})
+		}
+		addVerdicts(resp)
+		for i, edge := range resp.Data.ListArticles.Edges {
+			got := ""
+			if edge.Node.Verdict != nil {
+				got = edge.Node.Verdict.Verdict
+			}
+			if (edge.Node.Verdict == nil) != (len(edge.Node.ArticleReplies) == 0) || got != test.verdicts[i] {
+				t.Errorf("%s: verdict of %s = %+v, want %q", test.name, edge.Node.Id, edge.Node.Verdict, test.verdicts[i])
+			}
+		}
+		if !reflect.DeepEqual(resp.Verdict, test.overall) {
+			t.Errorf("%s: overall verdict = %+v, want %+v", test.name, resp.Verdict, test.overall)
+		}
+	}
+}